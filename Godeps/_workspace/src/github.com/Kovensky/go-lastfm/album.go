@@ -0,0 +1,38 @@
+package lastfm
+
+import (
+	"context"
+)
+
+// Gets information for an album. The user argument can either be empty ("") or specify a last.fm username, in which
+// case .UserPlaycount will be valid in the returned struct. The autocorrect parameter controls whether
+// last.fm's autocorrection algorithms should be run on the artist or album names.
+//
+// The AlbumInfo struct must specify either the MBID or both Artist and Name.
+// Example literals that can be given as the album argument:
+//
+//	lastfm.AlbumInfo{MBID: "mbid"}
+//	lastfm.AlbumInfo{Artist: "Artist", Name: "Album"}
+//
+// See http://www.last.fm/api/show/album.getInfo.
+func (lfm *LastFM) GetAlbumInfo(ctx context.Context, album AlbumInfo, user string, autocorrect bool) (info *AlbumInfo, err error) {
+	query := map[string]string{}
+	if autocorrect {
+		query["autocorrect"] = "1"
+	} else {
+		query["autocorrect"] = "0"
+	}
+
+	if user != "" {
+		query["username"] = user
+	}
+
+	if album.MBID != "" {
+		query["mbid"] = album.MBID
+	} else {
+		query["artist"] = album.Artist
+		query["album"] = album.Name
+	}
+
+	return call(ctx, lfm, "album.getInfo", query, func(s *lfmStatus) *AlbumInfo { return &s.AlbumInfo })
+}
@@ -0,0 +1,28 @@
+package lastfm_test
+
+import (
+	"context"
+	"github.com/Kovensky/go-lastfm"
+	"testing"
+	"time"
+)
+
+func TestGetAlbumInfo(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	info, err := lfm.GetAlbumInfo(context.Background(), lastfm.AlbumInfo{Artist: "Daft Punk", Name: "Discovery"}, "", false)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "name", "Discovery", info.Name)
+		Expect(T, "MBID", "8343b377-ea18-4d64-b5f6-ffaf55d8f55b", info.MBID)
+		Expect(T, "listeners", 1207612, info.Listeners)
+		Expect(T, "large image", "http://userserve-ak.last.fm/serve/174s/66072700.png", info.Images.URL(lastfm.LargeImage))
+		if Expect(T, "track count", 2, len(info.Tracks)) {
+			Expect(T, "second track", "Aerodynamic", info.Tracks[1].Name)
+			Expect(T, "second track's number", 2, info.Tracks[1].Rank)
+			Expect(T, "second track's duration", 212*time.Second, info.Tracks[1].Duration)
+		}
+		Expect(T, "top tag", "electronic", info.TopTags[0])
+		Expect(T, "wiki summary", "Discovery is the second studio album by Daft Punk, released in 2001.", info.Wiki.Summary)
+	}
+}
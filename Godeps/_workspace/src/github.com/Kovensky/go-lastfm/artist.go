@@ -0,0 +1,98 @@
+package lastfm
+
+import (
+	"context"
+	"strconv"
+)
+
+type ArtistInfo struct {
+	Name   string `xml:"name"`
+	MBID   string `xml:"mbid"`
+	URL    string `xml:"url"`
+	Images Images `xml:"image"`
+	OnTour bool   `xml:"ontour"`
+
+	Listeners      int `xml:"stats>listeners"`
+	TotalPlaycount int `xml:"stats>playcount"`
+
+	Similar []Artist `xml:"similar>artist"` // A few of the most similar artists
+	Tags    []string `xml:"tags>tag>name"`
+	Bio     *Wiki    `xml:"bio"`
+
+	// Only present if the user parameter isn't empty ("")
+	UserPlaycount int `xml:"stats>userplaycount"`
+}
+
+func (info *ArtistInfo) unmarshalHelper() (err error) {
+	if info.Bio != nil {
+		err = info.Bio.unmarshalHelper()
+	}
+	return
+}
+
+// Gets information for an Artist. The user argument can either be empty ("") or specify a last.fm username, in which
+// case .UserPlaycount will be valid in the returned struct. The autocorrect parameter controls whether
+// last.fm's autocorrection algorithms should be run on the artist name.
+//
+// The Artist struct must specify either the MBID or the Name.
+// Example literals that can be given as the artist argument:
+//
+//	lastfm.Artist{MBID: "mbid"}
+//	lastfm.Artist{Name: "Artist"}
+//
+// See http://www.last.fm/api/show/artist.getInfo.
+func (lfm *LastFM) GetArtistInfo(ctx context.Context, artist Artist, user string, autocorrect bool) (info *ArtistInfo, err error) {
+	query := artistQuery(artist, autocorrect)
+	if user != "" {
+		query["username"] = user
+	}
+
+	return call(ctx, lfm, "artist.getInfo", query, func(s *lfmStatus) *ArtistInfo { return &s.ArtistInfo })
+}
+
+type SimilarArtists struct {
+	Artist  string   `xml:"artist,attr"`
+	Artists []Artist `xml:"artist"` // The most similar first, with .Match set
+}
+
+// Gets up to limit artists similar to an Artist. The artist and autocorrect
+// arguments work as in GetArtistInfo.
+//
+// See http://www.last.fm/api/show/artist.getSimilar.
+func (lfm *LastFM) GetSimilarArtists(ctx context.Context, artist Artist, limit int, autocorrect bool) (similar *SimilarArtists, err error) {
+	query := artistQuery(artist, autocorrect)
+	query["limit"] = strconv.Itoa(limit)
+
+	return call(ctx, lfm, "artist.getSimilar", query, func(s *lfmStatus) *SimilarArtists { return &s.SimilarArtists })
+}
+
+// Gets a page of up to limit of the most played tracks of an Artist. Pages
+// start at 1. The returned struct's .Artist is set instead of .User and
+// .Period. The artist and autocorrect arguments work as in GetArtistInfo.
+//
+// See http://www.last.fm/api/show/artist.getTopTracks.
+func (lfm *LastFM) GetArtistTopTracks(ctx context.Context, artist Artist, page, limit int, autocorrect bool) (top *TopTracks, err error) {
+	query := artistQuery(artist, autocorrect)
+	query["page"] = strconv.Itoa(page)
+	query["limit"] = strconv.Itoa(limit)
+
+	return call(ctx, lfm, "artist.getTopTracks", query, func(s *lfmStatus) *TopTracks { return &s.TopTracks })
+}
+
+// Returns the query that identifies an artist, by MBID or by name, in the
+// artist.* methods.
+func artistQuery(artist Artist, autocorrect bool) map[string]string {
+	query := map[string]string{}
+	if autocorrect {
+		query["autocorrect"] = "1"
+	} else {
+		query["autocorrect"] = "0"
+	}
+
+	if artist.MBID != "" {
+		query["mbid"] = artist.MBID
+	} else {
+		query["artist"] = artist.Name
+	}
+	return query
+}
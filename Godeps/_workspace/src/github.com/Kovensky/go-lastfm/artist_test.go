@@ -0,0 +1,62 @@
+package lastfm_test
+
+import (
+	"context"
+	"github.com/Kovensky/go-lastfm"
+	"testing"
+	"time"
+)
+
+func TestGetArtistInfo(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	info, err := lfm.GetArtistInfo(context.Background(), lastfm.Artist{Name: "Daft Punk"}, "Kovensky", false)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "MBID", "056e4f3e-d505-4dad-8ec1-d04f521cbb56", info.MBID)
+		Expect(T, "listeners", 2495427, info.Listeners)
+		Expect(T, "user playcount", 2217, info.UserPlaycount)
+		Expect(T, "on tour status to be", true, info.OnTour)
+		Expect(T, "medium image", "http://userserve-ak.last.fm/serve/64/88565431.png", info.Images.URL(lastfm.MediumImage))
+		Expect(T, "largest image", "http://userserve-ak.last.fm/serve/500/88565431/Daft+Punk.png", info.Images.Largest())
+		if Expect(T, "similar artist count", 2, len(info.Similar)) {
+			Expect(T, "most similar artist", "Justice", info.Similar[0].Name)
+			Expect(T, "most similar artist's largest image", "http://userserve-ak.last.fm/serve/64/60845069.jpg",
+				info.Similar[0].Images.Largest())
+		}
+		Expect(T, "top tag", "electronic", info.Tags[0])
+		Expect(T, "bio publication date", time.Date(2013, 5, 16, 16, 41, 20, 0, time.UTC).Unix(), info.Bio.Published.Unix())
+	}
+}
+
+func TestGetSimilarArtists(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	similar, err := lfm.GetSimilarArtists(
+		context.Background(), lastfm.Artist{MBID: "056e4f3e-d505-4dad-8ec1-d04f521cbb56"}, 2, false)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "artist", "Daft Punk", similar.Artist)
+		if Expect(T, "artist count", 2, len(similar.Artists)) {
+			Expect(T, "second artist", "Thomas Bangalter", similar.Artists[1].Name)
+			Expect(T, "second artist's match", float32(0.871283), similar.Artists[1].Match)
+			Expect(T, "second artist's large image", "", similar.Artists[1].Images.URL(lastfm.LargeImage))
+		}
+	}
+}
+
+func TestGetArtistTopTracks(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	top, err := lfm.GetArtistTopTracks(context.Background(), lastfm.Artist{Name: "Daft Punk"}, 1, 2, true)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "artist", "Daft Punk", top.Artist)
+		Expect(T, "page count", 1306, top.TotalPages)
+		if Expect(T, "track count", 2, len(top.Tracks)) {
+			Expect(T, "top track", "Get Lucky", top.Tracks[0].Name)
+			Expect(T, "top track's listeners", 688215, top.Tracks[0].Listeners)
+			Expect(T, "second track's duration", 320*time.Second, top.Tracks[1].Duration)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package lastfm
+
+import (
+	"context"
+	"net/url"
+)
+
+// An authenticated session of a user. Methods that act on behalf of a user
+// take one; they need a LastFM created with NewWithSecret.
+//
+// Session keys don't expire, unless the user revokes the application's access.
+type Session struct {
+	User       string `xml:"name"`
+	Key        string `xml:"key"`
+	Subscriber bool   `xml:"subscriber"`
+}
+
+// Gets a token for web authentication. The user authorizes it by visiting
+// AuthURL(token), after which it can be exchanged for a Session with
+// GetSession. Tokens are valid for 60 minutes.
+//
+// See http://www.last.fm/api/show/auth.getToken.
+func (lfm *LastFM) GetToken(ctx context.Context) (token string, err error) {
+	t, _, err := send(ctx, lfm, &request{method: "auth.getToken", signed: true},
+		func(s *lfmStatus) *string { return &s.Token })
+	if t != nil {
+		token = *t
+	}
+	return
+}
+
+// Returns the page where the user authorizes the token.
+//
+// See http://www.last.fm/api/webauth.
+func (lfm *LastFM) AuthURL(token string) string {
+	u := authURL
+	u.RawQuery = url.Values{"api_key": {lfm.apiKey}, "token": {token}}.Encode()
+	return u.String()
+}
+
+// Exchanges a token authorized by the user for a Session. Fails with error
+// code 14 if the user hasn't authorized it yet.
+//
+// See http://www.last.fm/api/show/auth.getSession.
+func (lfm *LastFM) GetSession(ctx context.Context, token string) (session *Session, err error) {
+	session, _, err = send(ctx, lfm, &request{
+		method: "auth.getSession",
+		query:  map[string]string{"token": token},
+		signed: true,
+	}, func(s *lfmStatus) *Session { return &s.Session })
+	return
+}
+
+// Gets a Session with the user's username and password, for applications
+// that can't send the user to a web page.
+//
+// See http://www.last.fm/api/show/auth.getMobileSession.
+func (lfm *LastFM) GetMobileSession(ctx context.Context, username, password string) (session *Session, err error) {
+	session, _, err = send(ctx, lfm, &request{
+		method: "auth.getMobileSession",
+		query:  map[string]string{"username": username, "password": password},
+		signed: true,
+		post:   true,
+	}, func(s *lfmStatus) *Session { return &s.Session })
+	return
+}
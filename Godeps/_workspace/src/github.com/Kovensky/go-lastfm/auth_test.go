@@ -0,0 +1,60 @@
+package lastfm_test
+
+import (
+	"context"
+	"github.com/Kovensky/go-lastfm"
+	"testing"
+)
+
+func TestGetToken(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.NewWithSecret("4c563adf68bc357a4570d3e7986f6481", "secret"))
+	token, err := lfm.GetToken(context.Background())
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "token", "cf45fe5a3e3cebe168480a086d7fe481", token)
+		Expect(T, "auth URL",
+			"https://www.last.fm/api/auth/?api_key=4c563adf68bc357a4570d3e7986f6481&token=cf45fe5a3e3cebe168480a086d7fe481",
+			lfm.AuthURL(token))
+	}
+}
+
+func TestGetToken_NoSecret(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	_, err := lfm.GetToken(context.Background())
+
+	Expect(T, "error", lastfm.ErrNoSecret, err)
+}
+
+func TestGetSession(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.NewWithSecret("4c563adf68bc357a4570d3e7986f6481", "secret"))
+	session, err := lfm.GetSession(context.Background(), "cf45fe5a3e3cebe168480a086d7fe481")
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "user", "Kovensky", session.User)
+		Expect(T, "session key", "d580d57f32848f5dcf574d1ce18d78b2", session.Key)
+		Expect(T, "subscriber", false, session.Subscriber)
+	}
+}
+
+func TestGetSession_Unauthorized(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.NewWithSecret("4c563adf68bc357a4570d3e7986f6481", "secret"))
+	_, err := lfm.GetSession(context.Background(), "00000000000000000000000000000000")
+
+	if lfmErr, ok := err.(*lastfm.LastFMError); !ok || lfmErr.Code != 14 {
+		T.Errorf("Expected error code 14 -- Got %#v", err)
+	}
+}
+
+func TestFormats_Auth(T *testing.T) {
+	T.Parallel()
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetToken(context.Background())
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetSession(context.Background(), "cf45fe5a3e3cebe168480a086d7fe481")
+	})
+}
@@ -1,11 +1,14 @@
 package lastfm
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
@@ -15,13 +18,25 @@ import (
 func init() {
 	gob.Register(cache.Item{})
 
+	gob.Register(AlbumInfo{})
+	gob.Register(ArtistInfo{})
+	gob.Register(Friends{})
 	gob.Register(LastFMError{})
+	gob.Register(LovedTracks{})
 	gob.Register(Neighbours{})
 	gob.Register(RecentTracks{})
+	gob.Register(SearchResults{})
+	gob.Register(SimilarArtists{})
+	gob.Register(SimilarTracks{})
 	gob.Register(Tasteometer{})
+	gob.Register(TopAlbums{})
 	gob.Register(TopArtists{})
 	gob.Register(TopTags{})
+	gob.Register(TopTracks{})
 	gob.Register(TrackInfo{})
+	gob.Register(UserInfo{})
+	gob.Register(WeeklyChart{})
+	gob.Register(WeeklyChartList{})
 }
 
 const (
@@ -29,6 +44,65 @@ const (
 	DefaultCleanupInterval = 1 * time.Minute
 )
 
+// Stores the results of API calls, under keys made from the method and its
+// parameters. Implementations must be safe for concurrent use.
+//
+// MemoryCache, LRUCache and DiskCache implement it, and KeyLister.
+type Cache interface {
+	// Returns the value stored under key, unless there is none or it expired.
+	Get(key string) (v interface{}, ok bool)
+	// Stores v under key for ttl; a ttl of 0 or less means it doesn't expire.
+	Set(key string, v interface{}, ttl time.Duration)
+	Delete(key string)
+	Stats() CacheStats
+}
+
+type CacheStats struct {
+	Items     int // Entries stored, possibly including expired ones not removed yet
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64 // Entries removed to make room for others
+}
+
+// An entry of a SnapshotCache.
+type CacheItem struct {
+	Value   interface{}
+	Expires time.Time // Zero if it doesn't expire
+}
+
+func (item *CacheItem) expired(now time.Time) bool {
+	return !item.Expires.IsZero() && item.Expires.Before(now)
+}
+
+// Returns how long until the item expires, or 0 if it doesn't.
+func (item *CacheItem) ttl(now time.Time) time.Duration {
+	if item.Expires.IsZero() {
+		return 0
+	}
+	return item.Expires.Sub(now)
+}
+
+// A Cache kept in memory, which SaveCache writes out and LoadCache reads back
+// as a whole.
+type SnapshotCache interface {
+	Cache
+	// Returns the entries that haven't expired.
+	Items() map[string]CacheItem
+	// Adds the entries that haven't expired, replacing the ones with the same
+	// keys.
+	AddItems(items map[string]CacheItem)
+}
+
+// Returned by SaveCache and LoadCache when the Cache isn't a SnapshotCache.
+var ErrNoSnapshot = errors.New("lastfm: the cache can't be saved or loaded as a whole")
+
+// Escapes the separators of cache keys in parameter names and values, so that
+// keys can be split back into their parameters.
+var (
+	cacheKeyEscaper   = strings.NewReplacer("%", "%25", "&", "%26", "=", "%3D")
+	cacheKeyUnescaper = strings.NewReplacer("%25", "%", "%26", "&", "%3D", "=")
+)
+
 func makeCacheKey(method string, query map[string]string) string {
 	keys := make([]string, 0, len(query))
 	for key, _ := range query {
@@ -39,18 +113,23 @@ func makeCacheKey(method string, query map[string]string) string {
 	parts := make([]string, 0, len(query)+1)
 	parts = append(parts, method)
 	for _, key := range keys {
-		parts = append(parts, strings.Join([]string{key, query[key]}, "="))
+		parts = append(parts, cacheKeyEscaper.Replace(key)+"="+cacheKeyEscaper.Replace(query[key]))
 	}
 
 	return strings.Join(parts, "&")
 }
 
 func (lfm *LastFM) cacheGet(method string, query map[string]string) (v interface{}, err error) {
+	if lfm.Cache == nil || lfm.cachePolicy(method).NoCache {
+		return nil, nil
+	}
 	key := makeCacheKey(method, query)
 	if data, ok := lfm.Cache.Get(key); !ok {
 		return nil, nil
 	} else {
 		switch v := data.(type) {
+		case LastFMError: // as loaded by LoadCache
+			return nil, &v
 		case error:
 			return nil, v
 		default:
@@ -60,64 +139,168 @@ func (lfm *LastFM) cacheGet(method string, query map[string]string) (v interface
 }
 
 func (lfm *LastFM) cacheSet(method string, query map[string]string, v interface{}, hdr http.Header) {
-	now := time.Now()
-
-	end := now
-
-	if hdr != nil {
-		if _, ok := hdr["Cache-Control"]; ok {
-			for _, control := range hdr["Cache-Control"] {
-				ctrl := strings.Split(control, "=")
-				if len(ctrl) > 0 {
-					switch ctrl[0] {
-					case "no-cache":
-						return
-					case "max-age":
-						age, _ := strconv.ParseInt(ctrl[1], 10, 64)
-						end = now.Add(time.Duration(age) * time.Second)
-					}
-				}
-			}
-		} else if expires, ok := hdr["Expires"]; ok && len(expires) > 0 {
-			end, _ = time.Parse(time.RFC1123, expires[0])
-		}
+	if lfm.Cache == nil {
+		return
 	}
-
-	if dur := end.Sub(now); dur > 0 {
+	if ttl := lfm.cacheTTL(method, v, hdr); ttl > 0 {
 		key := makeCacheKey(method, query)
-		lfm.Cache.Set(key, v, dur)
+		lfm.Cache.Set(key, v, ttl)
 	}
 }
 
-// Writes a gob-encoded representation of the Cache to the
-// given io.Writer.
+func (lfm *LastFM) cacheDelete(method string, query map[string]string) {
+	if lfm.Cache != nil {
+		lfm.Cache.Delete(makeCacheKey(method, query))
+	}
+}
+
+// The format SaveCache writes: cacheFileMagic, the format version and the
+// number of entries as uvarints, and then the entries, stored like DiskCache
+// stores them.
+const (
+	cacheFileMagic   = "go-lastfm cache\n"
+	cacheFileVersion = 2 // 1 was a gob-encoded map of go-cache items, without a header
+)
+
+// Returned by LoadCache when some entries couldn't be read; the others were
+// loaded.
+type CacheLoadError struct {
+	Skipped int
+	Total   int
+	Err     error // Why the first one was skipped
+}
+
+func (e *CacheLoadError) Error() string {
+	return fmt.Sprintf("lastfm: skipped %d of %d cache entries: %v", e.Skipped, e.Total, e.Err)
+}
+
+func (e *CacheLoadError) Unwrap() error {
+	return e.Err
+}
+
+// Writes the entries of the Cache, which must be a SnapshotCache, to the
+// given io.Writer. Entries whose values can't be gob-encoded are left out.
 func (lfm *LastFM) SaveCache(w io.Writer) error {
-	lfm.Cache.RLock()
-	defer lfm.Cache.RUnlock()
+	c, ok := lfm.Cache.(SnapshotCache)
+	if !ok {
+		return ErrNoSnapshot
+	}
 
-	enc := gob.NewEncoder(w)
-	return enc.Encode(lfm.Cache.Items())
+	// Encoded first, so that the header has the right count
+	var records [][]byte
+	for key, item := range c.Items() {
+		value, err := encodeCacheValue(item.Value)
+		if err != nil {
+			continue
+		}
+		rec := diskRecord{key: key, value: value}
+		if !item.Expires.IsZero() {
+			rec.expires = item.Expires.UnixNano()
+		}
+		records = append(records, rec.encode())
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(cacheFileMagic)
+	bw.Write(binary.AppendUvarint(nil, cacheFileVersion))
+	bw.Write(binary.AppendUvarint(nil, uint64(len(records))))
+	for _, data := range records {
+		bw.Write(data)
+	}
+	return bw.Flush()
 }
 
-// Reads a gob-encoded representation of the Cache from the
-// given io.Reader. Does not change the current Cache if
-// there is a read or decoding error.
+// Reads entries written by SaveCache from the given io.Reader, and adds them
+// to the Cache, which must be a SnapshotCache.
 //
-// The Cache will have its parameters set to this package's
-// DefaultDuration and DefaultCleanupInterval. To change
-// the cache's duration/interval, a new cache is needed
-// like so:
-//
-//     lfm.Cache = cache.NewFrom(duration, interval, lfm.Cache.Items())
-//
-// This method is not safe for concurrent access, use before
-// starting any requests.
+// Entries that can't be read, such as ones whose values are of types that no
+// longer exist, are skipped, and reported with a *CacheLoadError once the
+// others are loaded. If the header can't be read, or the version is unknown,
+// the Cache isn't changed.
 func (lfm *LastFM) LoadCache(r io.Reader) error {
+	c, ok := lfm.Cache.(SnapshotCache)
+	if !ok {
+		return ErrNoSnapshot
+	}
+
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(cacheFileMagic)); string(magic) != cacheFileMagic {
+		return loadCacheV1(c, br)
+	}
+	br.Discard(len(cacheFileMagic))
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+	if version != cacheFileVersion {
+		return fmt.Errorf("lastfm: unknown cache format version %d", version)
+	}
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+
+	items := map[string]CacheItem{}
+	loadErr := &CacheLoadError{Total: int(count)}
+	for i := 0; i < loadErr.Total; i++ {
+		data, err := readDiskRecord(br)
+		if err != nil {
+			// Cut short, or a length is corrupted; the rest is lost
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			loadErr.Skipped += loadErr.Total - i
+			if loadErr.Err == nil {
+				loadErr.Err = err
+			}
+			break
+		}
+
+		rec, err := decodeDiskRecord(data)
+		var v interface{}
+		if err == nil {
+			v, err = decodeCacheValue(rec.value)
+		}
+		if err != nil {
+			loadErr.Skipped++
+			if loadErr.Err == nil {
+				loadErr.Err = err
+			}
+			continue
+		}
+		item := CacheItem{Value: v}
+		if rec.expires != 0 {
+			item.Expires = time.Unix(0, rec.expires)
+		}
+		items[rec.key] = item
+	}
+	c.AddItems(items)
+
+	if loadErr.Skipped > 0 {
+		return loadErr
+	}
+	return nil
+}
+
+// Loads the first version of the format, which is read all at once.
+func loadCacheV1(c SnapshotCache, r io.Reader) error {
 	dec := gob.NewDecoder(r)
 	var items map[string]*cache.Item
 	if err := dec.Decode(&items); err != nil {
 		return err
 	}
-	lfm.Cache = cache.NewFrom(DefaultDuration, DefaultCleanupInterval, items)
+	loaded := make(map[string]CacheItem, len(items))
+	for key, item := range items {
+		loaded[key] = CacheItem{Value: item.Object, Expires: expiration(item)}
+	}
+	c.AddItems(loaded)
 	return nil
 }
+
+// Returns the time a go-cache item expires at, or zero if it doesn't.
+func expiration(item *cache.Item) time.Time {
+	if item.Expiration == nil {
+		return time.Time{}
+	}
+	return *item.Expiration
+}
@@ -0,0 +1,176 @@
+package lastfm
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tells how long results are cached. Unless the policy says otherwise, they're
+// cached for as long as the response's Cache-Control or Expires header allows.
+type CachePolicy struct {
+	NoCache bool          // Never cached, nor looked up in the cache
+	MinTTL  time.Duration // Cached at least this long, even if the response says not to
+	MaxTTL  time.Duration // Cached at most this long; 0 for no limit
+
+	// How long Last.fm errors with the given codes are cached, regardless of
+	// the other settings; 0 doesn't cache them. Errors with codes missing
+	// from it are cached like results, except temporary ones, which are
+	// never cached.
+	ErrorTTLs map[ErrorCode]time.Duration
+}
+
+// The CachePolicy of LastFM by default: errors saying that something doesn't
+// exist are only cached for a minute, in case it's created, as when a user
+// signs up.
+func defaultCachePolicy() CachePolicy {
+	return CachePolicy{ErrorTTLs: map[ErrorCode]time.Duration{
+		ErrInvalidParameters: time.Minute,
+		ErrInvalidResource:   time.Minute,
+	}}
+}
+
+// The MethodCachePolicies of LastFM by default: the recent tracks, which tell
+// what users are playing now, are never cached.
+func defaultMethodCachePolicies() map[string]CachePolicy {
+	return map[string]CachePolicy{
+		"user.getRecentTracks": {NoCache: true},
+	}
+}
+
+// Returns the policy for the method.
+func (lfm *LastFM) cachePolicy(method string) CachePolicy {
+	if policy, ok := lfm.MethodCachePolicies[method]; ok {
+		return policy
+	}
+	return lfm.CachePolicy
+}
+
+// Returns how long v, the result of a call to method, is cached; 0 or less
+// if it isn't.
+func (lfm *LastFM) cacheTTL(method string, v interface{}, hdr http.Header) time.Duration {
+	policy := lfm.cachePolicy(method)
+	if policy.NoCache {
+		return 0
+	}
+	if lfmErr, ok := v.(*LastFMError); ok {
+		// The method's own TTL for the code, or else the general one
+		for _, errorTTLs := range []map[ErrorCode]time.Duration{policy.ErrorTTLs, lfm.CachePolicy.ErrorTTLs} {
+			if ttl, ok := errorTTLs[lfmErr.Code]; ok {
+				return ttl
+			}
+		}
+		// Likely gone by the next call
+		if IsTemporary(lfmErr) {
+			return 0
+		}
+	}
+
+	ttl := headerTTL(hdr, time.Now())
+	if ttl < policy.MinTTL {
+		ttl = policy.MinTTL
+	}
+	if policy.MaxTTL > 0 && ttl > policy.MaxTTL {
+		ttl = policy.MaxTTL
+	}
+	return ttl
+}
+
+// Returns how long the response with the headers may be cached, according
+// to its Cache-Control header, or else its Expires header.
+func headerTTL(hdr http.Header, now time.Time) time.Duration {
+	if controls, ok := hdr["Cache-Control"]; ok {
+		var ttl time.Duration
+		for _, control := range controls {
+			for _, directive := range strings.Split(control, ",") {
+				name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+				switch strings.ToLower(name) {
+				case "no-cache", "no-store":
+					return 0
+				case "max-age":
+					age, _ := strconv.ParseInt(value, 10, 64)
+					ttl = time.Duration(age) * time.Second
+				}
+			}
+		}
+		return ttl
+	}
+	if expires := hdr.Get("Expires"); expires != "" {
+		if end, err := time.Parse(time.RFC1123, expires); err == nil {
+			return end.Sub(now)
+		}
+	}
+	return 0
+}
+
+// Removes the cached results of method whose parameters include params, and
+// returns how many were removed. For example, this removes the cached
+// results of GetUserInfo for a user, so that they're requested again:
+//
+//	lfm.Invalidate("user.getInfo", map[string]string{"user": "Kovensky"})
+//
+// Parameters are named as in the Last.fm API. Names of users, artists, albums
+// and tracks match regardless of case, as they do for Last.fm.
+//
+// If the Cache doesn't implement KeyLister, params must be every parameter of
+// the cached call, with the same case, and 0 is returned, as there's no
+// telling whether it was cached.
+func (lfm *LastFM) Invalidate(method string, params map[string]string) (removed int) {
+	if lfm.Cache == nil {
+		return 0
+	}
+	lister, ok := lfm.Cache.(KeyLister)
+	if !ok {
+		lfm.Cache.Delete(makeCacheKey(method, params))
+		return 0
+	}
+
+	for _, key := range lister.Keys() {
+		if cacheKeyMatches(key, method, params) {
+			lfm.Cache.Delete(key)
+			removed++
+		}
+	}
+	return
+}
+
+// Implemented by caches that can list their keys, so that Invalidate can find
+// the entries to remove.
+type KeyLister interface {
+	Keys() []string
+}
+
+// Parameters whose values Last.fm matches regardless of case.
+var caselessCacheParams = map[string]bool{
+	"user":     true,
+	"username": true,
+	"artist":   true,
+	"album":    true,
+	"track":    true,
+}
+
+// Returns whether the key, made by makeCacheKey, is for method, with
+// parameters that include params.
+func cacheKeyMatches(key, method string, params map[string]string) bool {
+	parts := strings.Split(key, "&")
+	if parts[0] != method {
+		return false
+	}
+	query := make(map[string]string, len(parts)-1)
+	for _, part := range parts[1:] {
+		name, value, _ := strings.Cut(part, "=")
+		query[cacheKeyUnescaper.Replace(name)] = cacheKeyUnescaper.Replace(value)
+	}
+
+	for name, want := range params {
+		value, ok := query[name]
+		if !ok {
+			return false
+		}
+		if value != want && !(caselessCacheParams[name] && strings.EqualFold(value, want)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package lastfm
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHeaderTTL(T *testing.T) {
+	now := time.Date(2013, 5, 18, 18, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		hdr http.Header
+		ttl time.Duration
+	}{
+		{http.Header{"Cache-Control": {"max-age=300"}}, 5 * time.Minute},
+		{http.Header{"Cache-Control": {"public, max-age=60"}}, time.Minute},
+		{http.Header{"Cache-Control": {"no-cache"}, "Expires": {"Sat, 18 May 2013 19:00:00 UTC"}}, 0},
+		{http.Header{"Cache-Control": {"max-age"}}, 0},
+		{http.Header{"Expires": {"Sat, 18 May 2013 19:00:00 UTC"}}, time.Hour},
+		{http.Header{"Expires": {"0"}}, 0},
+		{nil, 0},
+	} {
+		if ttl := headerTTL(c.hdr, now); ttl != c.ttl {
+			T.Errorf("Expected %v for %v -- Got %v", c.ttl, c.hdr, ttl)
+		}
+	}
+}
+
+func TestCacheTTL(T *testing.T) {
+	lfm := New("key")
+	lfm.MethodCachePolicies["artist.getInfo"] = CachePolicy{
+		MinTTL:    time.Minute,
+		MaxTTL:    time.Hour,
+		ErrorTTLs: map[ErrorCode]time.Duration{ErrInvalidParameters: 0},
+	}
+	day := http.Header{"Cache-Control": {"max-age=86400"}}
+	for _, c := range []struct {
+		method string
+		v      interface{}
+		hdr    http.Header
+		ttl    time.Duration
+	}{
+		{"user.getInfo", &UserInfo{}, day, 24 * time.Hour},
+		{"user.getInfo", &UserInfo{}, nil, 0},
+		{"user.getInfo", &LastFMError{Code: ErrInvalidParameters}, day, time.Minute},
+		{"user.getInfo", &LastFMError{Code: ErrInvalidSessionKey}, day, 24 * time.Hour},
+		{"user.getInfo", &LastFMError{Code: ErrTemporarilyUnavailable}, day, 0},
+		{"artist.getInfo", &ArtistInfo{}, day, time.Hour},
+		{"artist.getInfo", &ArtistInfo{}, nil, time.Minute},
+		{"artist.getInfo", &LastFMError{Code: ErrInvalidParameters}, day, 0},
+		{"artist.getInfo", &LastFMError{Code: ErrInvalidResource}, day, time.Minute},
+		{"artist.getInfo", &LastFMError{Code: ErrServiceOffline}, day, 0},
+		{"user.getRecentTracks", &RecentTracks{}, day, 0},
+	} {
+		if ttl := lfm.cacheTTL(c.method, c.v, c.hdr); ttl != c.ttl {
+			T.Errorf("Expected %v for %s's %#v -- Got %v", c.ttl, c.method, c.v, ttl)
+		}
+	}
+}
+
+func TestCall_CachePolicy(T *testing.T) {
+	g := &sequenceGetter{responses: [][2]string{{"200 OK", `<lfm status="ok"><user><name>Kovensky</name></user></lfm>`}}}
+	lfm := New("key")
+	lfm.getter = g
+	lfm.MethodCachePolicies["user.getInfo"] = CachePolicy{MinTTL: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		if _, err := lfm.GetUserInfo(context.Background(), "Kovensky"); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if g.requests != 1 {
+		T.Errorf("Expected the second call to be cached -- Got %d requests", g.requests)
+	}
+
+	lfm.MethodCachePolicies["user.getInfo"] = CachePolicy{NoCache: true}
+	if _, err := lfm.GetUserInfo(context.Background(), "Kovensky"); err != nil {
+		T.Fatal(err)
+	}
+	if g.requests != 2 {
+		T.Errorf("Expected the cached result to be ignored -- Got %d requests", g.requests)
+	}
+}
+
+func TestCall_TemporaryErrorNotCached(T *testing.T) {
+	failed := `<lfm status="failed"><error code="8">Operation failed - Most likely the backend service failed. Please try again.</error></lfm>`
+	g := &sequenceGetter{responses: [][2]string{{"200 OK", failed}}}
+	lfm := New("key")
+	lfm.getter = g
+	lfm.MethodCachePolicies["user.getInfo"] = CachePolicy{MinTTL: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		if _, err := lfm.GetUserInfo(context.Background(), "Kovensky"); !IsTemporary(err) {
+			T.Fatalf("Expected a temporary error -- Got %v", err)
+		}
+	}
+	if g.requests != 2 {
+		T.Errorf("Expected the error not to be cached -- Got %d requests", g.requests)
+	}
+}
+
+// Hides the KeyLister of a Cache.
+type unlistedCache struct {
+	Cache
+}
+
+func TestInvalidate(T *testing.T) {
+	lfm := New("key")
+	for _, c := range []Cache{lfm.Cache, unlistedCache{NewMemoryCache(0)}} {
+		lfm.Cache = c
+		for _, query := range []map[string]string{
+			{"user": "Kovensky", "period": "overall"},
+			{"user": "Kovensky", "period": "7day"},
+			{"user": "someone", "period": "overall"},
+		} {
+			lfm.Cache.Set(makeCacheKey("user.getTopArtists", query), &TopArtists{}, 0)
+			lfm.Cache.Set(makeCacheKey("user.getTopAlbums", query), &TopAlbums{}, 0)
+		}
+
+		removed := lfm.Invalidate("user.getTopArtists", map[string]string{"user": "kovensky"})
+		if _, listed := c.(KeyLister); !listed {
+			// Only the exact key can be found, and removals can't be counted
+			if removed != 0 {
+				T.Errorf("Expected no entries counted without a KeyLister -- Got %d", removed)
+			}
+			lfm.Invalidate("user.getTopArtists", map[string]string{"user": "Kovensky", "period": "7day"})
+			if stats := lfm.Cache.Stats(); stats.Items != 5 || stats.Hits != 0 {
+				T.Errorf("Expected only the exact entry to be removed, without a hit -- Got %+v", stats)
+			}
+			continue
+		}
+		if removed != 2 {
+			T.Errorf("Expected 2 entries removed -- Got %d", removed)
+		}
+		if n := lfm.Cache.Stats().Items; n != 4 {
+			T.Errorf("Expected the other 4 entries to be kept -- Got %d", n)
+		}
+	}
+}
+
+func TestCacheKeyMatches(T *testing.T) {
+	key := makeCacheKey("track.getInfo", map[string]string{
+		"artist":   "Simon & Garfunkel",
+		"track":    "E=MC2",
+		"username": "Kovensky",
+		"mbid":     "100%",
+	})
+	for _, c := range []struct {
+		method  string
+		params  map[string]string
+		matches bool
+	}{
+		{"track.getInfo", nil, true},
+		{"track.getInfo", map[string]string{"artist": "Simon & Garfunkel", "track": "E=MC2"}, true},
+		{"track.getInfo", map[string]string{"artist": "simon & garfunkel", "username": "KOVENSKY"}, true},
+		{"track.getInfo", map[string]string{"mbid": "100%"}, true},
+		{"track.getInfo", map[string]string{"artist": "Simon"}, false},
+		{"track.getInfo", map[string]string{"artist": "Simon & Garfunkel&track=E"}, false},
+		{"track.getInfo", map[string]string{"track": "E"}, false},
+		{"track.getInfo", map[string]string{"mbid": "100%25"}, false},
+		{"track.getInfo", map[string]string{"autocorrect": "1"}, false},
+		{"track.getInfoX", nil, false},
+		{"track", nil, false},
+	} {
+		if matches := cacheKeyMatches(key, c.method, c.params); matches != c.matches {
+			T.Errorf("Expected %v for %s %v -- Got %v", c.matches, c.method, c.params, matches)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package lastfm
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pmylund/go-cache"
+)
+
+func openTestDiskCache(T *testing.T, path string) *DiskCache {
+	c, err := OpenDiskCache(path)
+	if err != nil {
+		T.Fatal(err)
+	}
+	T.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCaches(T *testing.T) {
+	for name, c := range map[string]Cache{
+		"memory": NewMemoryCache(0),
+		"lru":    NewLRUCache(10),
+		"disk":   openTestDiskCache(T, filepath.Join(T.TempDir(), "cache")),
+	} {
+		c.Set("kept", &TrackInfo{Name: "Motherboard"}, 0)
+		c.Set("expired", &TrackInfo{Name: "Contact"}, time.Nanosecond)
+		c.Set("deleted", LastFMError{Code: ErrInvalidParameters}, time.Hour)
+		c.Delete("deleted")
+		time.Sleep(time.Millisecond)
+
+		switch v, _ := c.Get("kept"); v := v.(type) {
+		case *TrackInfo: // in memory
+			if v.Name != "Motherboard" {
+				T.Errorf("%s: Expected the track Motherboard -- Got %q", name, v.Name)
+			}
+		case TrackInfo: // decoded
+			if v.Name != "Motherboard" {
+				T.Errorf("%s: Expected the track Motherboard -- Got %q", name, v.Name)
+			}
+		default:
+			T.Errorf("%s: Expected a TrackInfo -- Got %#v", name, v)
+		}
+		for _, key := range []string{"expired", "deleted", "missing"} {
+			if v, ok := c.Get(key); ok {
+				T.Errorf("%s: Expected no %s entry -- Got %#v", name, key, v)
+			}
+		}
+		if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 3 {
+			T.Errorf("%s: Expected 1 hit and 3 misses -- Got %+v", name, stats)
+		}
+	}
+}
+
+func TestLRUCache(T *testing.T) {
+	c := NewLRUCache(2)
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+	c.Get("a")
+	c.Set("c", 3, 0)
+
+	if _, ok := c.Get("b"); ok {
+		T.Error("Expected the least recently used entry to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			T.Errorf("Expected %q to be kept", key)
+		}
+	}
+	if stats := c.Stats(); stats.Items != 2 || stats.Evictions != 1 {
+		T.Errorf("Expected 2 entries after 1 eviction -- Got %+v", stats)
+	}
+
+	// Loaded entries don't push out the ones in use
+	c.AddItems(map[string]CacheItem{"d": {Value: 4}})
+	if _, ok := c.Get("d"); ok {
+		T.Error("Expected loaded entries to be dropped when full")
+	}
+}
+
+func TestMemoryCache_Cleanup(T *testing.T) {
+	c := NewMemoryCache(time.Millisecond)
+	c.Set("expired", 1, time.Nanosecond)
+	time.Sleep(2 * time.Millisecond)
+	c.Set("kept", 2, 0)
+	if n := c.Stats().Items; n != 1 {
+		T.Errorf("Expected the expired entry to be removed -- Got %d items", n)
+	}
+}
+
+// Run with -race; Items and Keys used to deadlock once a Set was waiting for
+// the lock.
+func TestMemoryCache_Concurrent(T *testing.T) {
+	c := NewMemoryCache(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Set(strconv.Itoa(j), j, time.Hour)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for key, item := range c.Items() {
+					if key != strconv.Itoa(item.Value.(int)) {
+						T.Errorf("Expected %q's value to be %s -- Got %v", key, key, item.Value)
+						return
+					}
+				}
+				c.Keys()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(c.Items()); n != 1000 {
+		T.Errorf("Expected 1000 items -- Got %d", n)
+	}
+}
+
+func TestSaveCache(T *testing.T) {
+	lfm := New("key")
+	lfm.Cache.Set("track", &TrackInfo{Name: "Motherboard"}, time.Hour)
+	lfm.Cache.Set("forever", &TrackInfo{Name: "Contact"}, 0)
+	var buf bytes.Buffer
+	if err := lfm.SaveCache(&buf); err != nil {
+		T.Fatal(err)
+	}
+
+	lfm.Cache = NewLRUCache(10)
+	if err := lfm.LoadCache(&buf); err != nil {
+		T.Fatal(err)
+	}
+	items := lfm.Cache.(SnapshotCache).Items()
+	if info, ok := items["track"].Value.(TrackInfo); !ok || info.Name != "Motherboard" {
+		T.Errorf("Expected the track Motherboard -- Got %#v", items["track"])
+	}
+	if until := time.Until(items["track"].Expires); until <= 0 || until > time.Hour {
+		T.Errorf("Expected the track to expire within the hour -- Got %v", items["track"].Expires)
+	}
+	if !items["forever"].Expires.IsZero() {
+		T.Errorf("Expected the entry to never expire -- Got %v", items["forever"].Expires)
+	}
+
+	lfm.Cache = openTestDiskCache(T, filepath.Join(T.TempDir(), "cache"))
+	if err := lfm.SaveCache(&buf); err != ErrNoSnapshot {
+		T.Errorf("Expected ErrNoSnapshot -- Got %v", err)
+	}
+}
+
+func TestLoadCache_BadEntries(T *testing.T) {
+	lfm := New("key")
+	for _, name := range []string{"Aerodynamic", "Contact", "Motherboard"} {
+		lfm.Cache.Set(name, &TrackInfo{Name: name}, 0)
+	}
+	var buf bytes.Buffer
+	if err := lfm.SaveCache(&buf); err != nil {
+		T.Fatal(err)
+	}
+	saved := buf.Bytes()
+	// Corrupts an entry, as a renamed type or a flipped bit would
+	data := bytes.Replace(saved, []byte("Contact"), []byte("Xontact"), 1)
+
+	lfm.Cache = NewMemoryCache(0)
+	var loadErr *CacheLoadError
+	if err := lfm.LoadCache(bytes.NewReader(data)); !errors.As(err, &loadErr) || loadErr.Skipped != 1 || loadErr.Total != 3 {
+		T.Errorf("Expected 1 of 3 entries to be skipped -- Got %v", err)
+	}
+	for _, key := range []string{"Aerodynamic", "Motherboard"} {
+		if _, ok := lfm.Cache.Get(key); !ok {
+			T.Errorf("Expected %s to be loaded", key)
+		}
+	}
+
+	// Cut short, as by a full disk
+	lfm.Cache = NewMemoryCache(0)
+	if err := lfm.LoadCache(bytes.NewReader(saved[:len(saved)-3])); !errors.As(err, &loadErr) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		T.Errorf("Expected the last entry to be cut short -- Got %v", err)
+	}
+	if n := lfm.Cache.Stats().Items; n != 2 {
+		T.Errorf("Expected 2 entries to be loaded -- Got %d", n)
+	}
+}
+
+func TestLoadCache_Versions(T *testing.T) {
+	lfm := New("key")
+	expires := time.Now().Add(time.Hour)
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(map[string]*cache.Item{
+		"track": {Object: &TrackInfo{Name: "Motherboard"}, Expiration: &expires},
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err := lfm.LoadCache(&buf); err != nil {
+		T.Fatal(err)
+	}
+	if v, _ := lfm.Cache.Get("track"); v == nil || v.(TrackInfo).Name != "Motherboard" {
+		T.Errorf("Expected the track Motherboard from the first version -- Got %#v", v)
+	}
+
+	lfm.Cache = NewMemoryCache(0)
+	if err := lfm.LoadCache(bytes.NewReader([]byte(cacheFileMagic + "\x03\x00"))); err == nil {
+		T.Error("Expected an unknown version to fail")
+	}
+}
+
+func TestDiskCache_Locked(T *testing.T) {
+	path := filepath.Join(T.TempDir(), "cache")
+	c := openTestDiskCache(T, path)
+	if _, err := OpenDiskCache(path); err != ErrCacheLocked {
+		T.Errorf("Expected ErrCacheLocked -- Got %v", err)
+	}
+	if _, err := LockCacheFile(path); err != ErrCacheLocked {
+		T.Errorf("Expected ErrCacheLocked from LockCacheFile -- Got %v", err)
+	}
+	c.Close()
+	openTestDiskCache(T, path)
+}
+
+func TestDiskCache_Reopen(T *testing.T) {
+	path := filepath.Join(T.TempDir(), "cache")
+	c := openTestDiskCache(T, path)
+	c.Set("a", "first", 0)
+	c.Set("a", "replaced", 0)
+	c.Set("b", "deleted", 0)
+	c.Delete("b")
+	c.Set("c", "torn", 0)
+	if err := c.Close(); err != nil {
+		T.Fatal(err)
+	}
+	// As if the program stopped while writing the last entry
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		T.Fatal(err)
+	}
+
+	c = openTestDiskCache(T, path)
+	if v, _ := c.Get("a"); v != "replaced" {
+		T.Errorf("Expected the replaced value -- Got %#v", v)
+	}
+	for _, key := range []string{"b", "c"} {
+		if v, ok := c.Get(key); ok {
+			T.Errorf("Expected no %s entry -- Got %#v", key, v)
+		}
+	}
+	// Written over the torn entry
+	c.Set("d", "after", 0)
+	c.Close()
+	c = openTestDiskCache(T, path)
+	if v, _ := c.Get("d"); v != "after" {
+		T.Errorf("Expected the entry written after reopening -- Got %#v", v)
+	}
+}
+
+func TestDiskCache_Compact(T *testing.T) {
+	path := filepath.Join(T.TempDir(), "cache")
+	c := openTestDiskCache(T, path)
+	value := string(make([]byte, 1024))
+	for i := 0; i < 4*diskCacheMinCompact/len(value); i++ {
+		c.Set(strconv.Itoa(i%10), value, 0)
+	}
+	if err := c.Sync(); err != nil {
+		T.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Size() >= diskCacheMinCompact {
+		T.Errorf("Expected the file to be compacted -- Got %d bytes", info.Size())
+	}
+	if stats := c.Stats(); stats.Items != 10 {
+		T.Errorf("Expected 10 entries -- Got %d", stats.Items)
+	}
+
+	c.Close()
+	c = openTestDiskCache(T, path)
+	if v, _ := c.Get("9"); v != value {
+		T.Error("Expected the entries to be kept by compaction")
+	}
+}
+
+func TestDiskCache_NotDiskCache(T *testing.T) {
+	path := filepath.Join(T.TempDir(), "cache")
+	if err := os.WriteFile(path, []byte("x\x9c something else"), 0644); err != nil {
+		T.Fatal(err)
+	}
+	if _, err := OpenDiskCache(path); !errors.Is(err, ErrNotDiskCache) {
+		T.Errorf("Expected ErrNotDiskCache -- Got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "x\x9c something else" {
+		T.Errorf("Expected the file to be left alone -- Got %q", data)
+	}
+}
@@ -1,80 +1,215 @@
 package lastfm
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"io"
 	"net/http"
 	"net/url"
-
-	"github.com/pmylund/go-cache"
+	"sort"
+	"strings"
+	"time"
 )
 
 var (
-	apiBaseURL = url.URL{Scheme: "http", Host: "ws.audioscrobbler.com", Path: "/2.0/"}
+	apiBaseURL = url.URL{Scheme: "https", Host: "ws.audioscrobbler.com", Path: "/2.0/"}
+	authURL    = url.URL{Scheme: "https", Host: "www.last.fm", Path: "/api/auth/"}
 )
 
-func buildQueryURL(query map[string]string) string {
-	v := url.Values{}
-	for key, value := range query {
-		v.Add(key, value)
-	}
+// Returned by methods that need to be signed when the LastFM has no secret.
+var ErrNoSecret = errors.New("lastfm: this method needs the API secret; use NewWithSecret")
+
+func buildQueryURL(query url.Values) string {
 	u := apiBaseURL
-	u.RawQuery = v.Encode()
+	u.RawQuery = query.Encode()
 	return u.String()
 }
 
 type getter interface {
-	Get(url string) (resp *http.Response, err error)
+	Do(req *http.Request) (resp *http.Response, err error)
 }
 
+// How long a request may take, including reading the response, unless the
+// context given to the method has an earlier deadline.
+const DefaultTimeout = 30 * time.Second
+
 type mockServer interface {
 	doQuery(params map[string]string) ([]byte, error)
 }
 
 // Struct used to access the API servers.
 type LastFM struct {
-	apiKey string
-	getter getter
-	Cache  *cache.Cache
+	apiKey  string
+	secret  string
+	getter  getter
+	Cache   Cache // A MemoryCache unless changed; nil disables caching
+	flights *flightGroup
+
+	// How results are cached: by the policy in MethodCachePolicies under the
+	// method's name, such as "user.getRecentTracks", or else by CachePolicy.
+	// By default, errors saying that something doesn't exist are cached for a
+	// minute, and the recent tracks aren't cached.
+	CachePolicy         CachePolicy
+	MethodCachePolicies map[string]CachePolicy
+
+	// The format of the responses; XML unless changed. Decoded results are
+	// the same either way.
+	Format Format
+
+	// Limits how often requests are sent; nil sends them as they come.
+	// Cached results don't count.
+	Limiter *RateLimiter
+	// How requests that fail temporarily are retried.
+	Retry RetryPolicy
+	// If not nil, called when a request is delayed by the Limiter, retried, or
+	// dropped. It must not block.
+	Observer func(Event)
 }
 
 // Create a new LastFM struct.
 // The apiKey parameter must be an API key registered with Last.fm.
 func New(apiKey string) LastFM {
 	return LastFM{
-		apiKey: apiKey,
-		getter: http.DefaultClient,
-		Cache:  cache.New(DefaultDuration, DefaultCleanupInterval),
+		apiKey:  apiKey,
+		getter:  &http.Client{Timeout: DefaultTimeout},
+		Cache:   NewMemoryCache(DefaultCleanupInterval),
+		flights: newFlightGroup(),
+
+		CachePolicy:         defaultCachePolicy(),
+		MethodCachePolicies: defaultMethodCachePolicies(),
+
+		Limiter: NewRateLimiter(DefaultRate, DefaultBurst),
+		Retry:   DefaultRetryPolicy,
 	}
 }
 
-func (lfm *LastFM) doQuery(method string, params map[string]string) (body io.ReadCloser, hdr http.Header, err error) {
-	queryParams := make(map[string]string, len(params)+2)
-	queryParams["api_key"] = lfm.apiKey
-	queryParams["method"] = method
-	for key, value := range params {
-		queryParams[key] = value
+// Create a new LastFM struct that can also make signed calls, such as the
+// ones that authenticate users or act on their behalf.
+// The secret parameter is the shared secret Last.fm gives along with the apiKey.
+func NewWithSecret(apiKey, secret string) LastFM {
+	lfm := New(apiKey)
+	lfm.secret = secret
+	return lfm
+}
+
+// An API call.
+type request struct {
+	method  string
+	query   map[string]string
+	signed  bool     // Whether the call needs an api_sig
+	post    bool     // Whether the call changes something, and so is sent as a POST
+	session *Session // The session to act as, if any; implies signed
+}
+
+// Returns the parameters of the call, signed if needed.
+func (lfm *LastFM) params(r *request) (url.Values, error) {
+	params := make(url.Values, len(r.query)+5)
+	params.Set("api_key", lfm.apiKey)
+	params.Set("method", r.method)
+	for key, value := range r.query {
+		params.Set(key, value)
+	}
+	if lfm.Format == JSON {
+		params.Set("format", "json")
+	}
+	if r.session != nil {
+		params.Set("sk", r.session.Key)
+	}
+	if r.signed || r.session != nil {
+		if lfm.secret == "" {
+			return nil, ErrNoSecret
+		}
+		params.Set("api_sig", sign(params, lfm.secret))
+	}
+	return params, nil
+}
+
+// Signs the parameters as described in http://www.last.fm/api/authspec: the
+// MD5 of every parameter, sorted by name and concatenated as namevalue,
+// followed by the secret.
+func sign(params url.Values, secret string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if key != "format" && key != "callback" && key != "api_sig" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	h := md5.New()
+	for _, key := range keys {
+		io.WriteString(h, key)
+		io.WriteString(h, params.Get(key))
+	}
+	io.WriteString(h, secret)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (lfm *LastFM) doQuery(ctx context.Context, r *request) (resp *http.Response, err error) {
+	params, err := lfm.params(r)
+	if err != nil {
+		return nil, err
 	}
 
-	resp, err := lfm.getter.Get(buildQueryURL(queryParams))
+	var req *http.Request
+	if r.post {
+		req, err = http.NewRequest("POST", apiBaseURL.String(), strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequest("GET", buildQueryURL(params), nil)
+	}
+	if err != nil {
+		return nil, &RequestError{Method: r.method, Err: err}
+	}
+	resp, err = lfm.getter.Do(req.WithContext(ctx))
 	if err != nil {
 		if resp != nil && resp.Body != nil {
 			resp.Body.Close()
 		}
-		return
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err // don't leak the API key in the URL
+		}
+		return nil, &RequestError{Method: r.method, Err: err}
 	}
-	return resp.Body, resp.Header, err
+	return resp, nil
 }
 
-// Used to unwrap XML from inside the <lfm> parent
+// Used to unwrap XML from inside the <lfm> parent; JSON is turned into the
+// same XML by decodeJSON
 type lfmStatus struct {
-	Status       string       `xml:"status,attr"`
-	RecentTracks RecentTracks `xml:"recenttracks"`
-	Tasteometer  Tasteometer  `xml:"comparison"`
-	TrackInfo    TrackInfo    `xml:"track"`
-	TopTags      TopTags      `xml:"toptags"`
-	Neighbours   Neighbours   `xml:"neighbours>user"`
-	TopArtists   TopArtists   `xml:"topartists"`
-	Error        LastFMError  `xml:"error"`
+	Status            string          `xml:"status,attr"`
+	RecentTracks      RecentTracks    `xml:"recenttracks"`
+	Tasteometer       Tasteometer     `xml:"comparison"`
+	TrackInfo         TrackInfo       `xml:"track"`
+	TopTags           TopTags         `xml:"toptags"`
+	Neighbours        Neighbours      `xml:"neighbours>user"`
+	TopArtists        TopArtists      `xml:"topartists"`
+	TopTracks         TopTracks       `xml:"toptracks"`
+	TopAlbums         TopAlbums       `xml:"topalbums"`
+	LovedTracks       LovedTracks     `xml:"lovedtracks"`
+	UserInfo          UserInfo        `xml:"user"`
+	Friends           Friends         `xml:"friends"`
+	WeeklyChartList   WeeklyChartList `xml:"weeklychartlist"`
+	WeeklyArtistChart WeeklyChart     `xml:"weeklyartistchart"`
+	WeeklyAlbumChart  WeeklyChart     `xml:"weeklyalbumchart"`
+	WeeklyTrackChart  WeeklyChart     `xml:"weeklytrackchart"`
+	ArtistInfo        ArtistInfo      `xml:"artist"`
+	SimilarArtists    SimilarArtists  `xml:"similarartists"`
+	SimilarTracks     SimilarTracks   `xml:"similartracks"`
+	AlbumInfo         AlbumInfo       `xml:"album"`
+	SearchResults     SearchResults   `xml:"results"`
+	Token             string          `xml:"token"`
+	Session           Session         `xml:"session"`
+	Scrobbles         ScrobbleBatch   `xml:"scrobbles"`
+	NowPlaying        ScrobbleResult  `xml:"nowplaying"`
+	Error             LastFMError     `xml:"error"`
 }
 
 type lfmDate struct {
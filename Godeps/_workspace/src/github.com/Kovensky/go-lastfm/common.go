@@ -1,7 +1,6 @@
 package lastfm
 
 import (
-	"strings"
 	"time"
 )
 
@@ -10,14 +9,47 @@ type unmarshalHelper interface {
 	unmarshalHelper() error
 }
 
-type LastFMError struct {
-	error
-	Code    int    `xml:"code,attr"`
-	Message string `xml:",chardata"`
+// The sizes of images, from the smallest.
+type ImageSize string
+
+const (
+	SmallImage      ImageSize = "small"
+	MediumImage     ImageSize = "medium"
+	LargeImage      ImageSize = "large"
+	ExtraLargeImage ImageSize = "extralarge"
+	MegaImage       ImageSize = "mega"
+)
+
+var imageSizes = []ImageSize{SmallImage, MediumImage, LargeImage, ExtraLargeImage, MegaImage}
+
+type Image struct {
+	Size ImageSize `xml:"size,attr"`
+	URL  string    `xml:",chardata"`
+}
+
+// The sizes an image is available in.
+type Images []Image
+
+// Returns the URL of the image in the given size, or "" if it's not available
+// in that size.
+func (images Images) URL(size ImageSize) string {
+	for _, image := range images {
+		if image.Size == size {
+			return image.URL
+		}
+	}
+	return ""
 }
 
-func (e *LastFMError) Error() string {
-	return strings.Trim(e.Message, "\n ")
+// Returns the URL of the image in the largest size available, or "" if there
+// are none.
+func (images Images) Largest() string {
+	for i := len(imageSizes) - 1; i >= 0; i-- {
+		if url := images.URL(imageSizes[i]); url != "" {
+			return url
+		}
+	}
+	return ""
 }
 
 type Artist struct {
@@ -25,6 +57,12 @@ type Artist struct {
 	PlayCount int    `xml:"playcount"` // Currently is always 0, except when part of the result of GetUserTopArtists.
 	MBID      string `xml:"mbid"`
 	URL       string `xml:"url"`
+	Images    Images `xml:"image"`
+
+	// Only present in the results of SearchArtists
+	Listeners int `xml:"listeners"`
+	// Only present in the results of GetSimilarArtists and GetArtistInfo's .Similar
+	Match float32 `xml:"match"`
 }
 
 // Less detailed struct returned in GetRecentTracks.
@@ -33,13 +71,40 @@ type Album struct {
 	MBID string `xml:"mbid,attr"`
 }
 
-// More detailed struct returned in GetTrackInfo.
+// More detailed struct returned in GetTrackInfo, GetAlbumInfo and SearchAlbums.
 type AlbumInfo struct {
-	TrackNo int    `xml:"position,attr"`
+	TrackNo int    `xml:"position,attr"` // Only in GetTrackInfo
 	Name    string `xml:"title"`
 	Artist  string `xml:"artist"`
 	MBID    string `xml:"mbid"`
 	URL     string `xml:"url"`
+	Images  Images `xml:"image"`
+
+	// Only present in the result of GetAlbumInfo
+	Listeners      int        `xml:"listeners"`
+	TotalPlaycount int        `xml:"playcount"`
+	UserPlaycount  int        `xml:"userplaycount"` // Only if a user was given
+	Tracks         []TopTrack `xml:"tracks>track"`  // .Rank is the track number
+	TopTags        []string   `xml:"toptags>tag>name"`
+	Wiki           *Wiki      `xml:"wiki"`
+
+	// For internal use
+	RawName string `xml:"name"` // Instead of title, outside of GetTrackInfo
+}
+
+func (info *AlbumInfo) unmarshalHelper() (err error) {
+	if info.Name == "" {
+		info.Name = info.RawName
+	}
+	for i := range info.Tracks {
+		if err = info.Tracks[i].unmarshalHelper(); err != nil {
+			return
+		}
+	}
+	if info.Wiki != nil {
+		err = info.Wiki.unmarshalHelper()
+	}
+	return
 }
 
 type Track struct {
@@ -50,6 +115,7 @@ type Track struct {
 	Name       string    `xml:"name"`
 	MBID       string    `xml:"mbid"`
 	URL        string    `xml:"url"`
+	Images     Images    `xml:"image"`
 	Date       time.Time `xml:"-"`
 
 	// For internal use
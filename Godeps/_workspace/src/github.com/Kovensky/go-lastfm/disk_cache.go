@@ -0,0 +1,426 @@
+package lastfm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Starts the files of a DiskCache, and tells the version of their format.
+const diskCacheMagic = "go-lastfm kv 1\n"
+
+// A DiskCache's file is only compacted once it's at least this big.
+const diskCacheMinCompact = 1 << 20
+
+// Records bigger than this can only come from a corrupted length.
+const diskCacheMaxRecord = 64 << 20
+
+// Returned by OpenDiskCache when the file isn't a DiskCache's.
+var ErrNotDiskCache = errors.New("lastfm: not a disk cache file")
+
+var errBadRecord = errors.New("lastfm: corrupted disk cache record")
+
+// A Cache stored in a file, which only keeps its keys in memory, so that it
+// can hold far more entries than a MemoryCache, and survives restarts without
+// being saved as a whole.
+//
+// Every change is appended to the file as it's made; once most of the file is
+// taken by entries that were replaced, deleted or expired, it's rewritten
+// without them. Entries are checksummed, and the ones that can't be read, such
+// as one the program was writing when it stopped, are dropped.
+//
+// Values are gob-encoded, so their types must be registered with gob.Register;
+// the results of this package are. It is safe for concurrent use, and holds
+// the lock of LockCacheFile while open, so that other processes don't use the
+// file at the same time.
+type DiskCache struct {
+	mutex  sync.Mutex
+	path   string
+	file   *os.File
+	lock   io.Closer
+	index  map[string]diskEntry
+	size   int64 // Of the file
+	live   int64 // Bytes of the file taken by the entries in index
+	err    error // The first write error since the last Sync
+	hits   uint64
+	misses uint64
+}
+
+// Where an entry is in the file.
+type diskEntry struct {
+	offset  int64
+	length  int64
+	expires int64 // In Unix nanoseconds; 0 if it doesn't expire
+}
+
+// An entry as stored in the file: a header with the length and CRC-32 of the
+// body, then the body, with the key, the expiry time, whether the entry was
+// deleted, and the gob-encoded value.
+type diskRecord struct {
+	key     string
+	expires int64
+	deleted bool
+	value   []byte
+}
+
+const diskRecordHeader = 8
+
+func (rec *diskRecord) encode() []byte {
+	body := binary.AppendUvarint(nil, uint64(len(rec.key)))
+	body = append(body, rec.key...)
+	body = binary.BigEndian.AppendUint64(body, uint64(rec.expires))
+	if rec.deleted {
+		body = append(body, 1)
+	} else {
+		body = append(body, 0)
+	}
+	body = append(body, rec.value...)
+
+	data := make([]byte, diskRecordHeader, diskRecordHeader+len(body))
+	binary.BigEndian.PutUint32(data, uint32(len(body)))
+	binary.BigEndian.PutUint32(data[4:], crc32.ChecksumIEEE(body))
+	return append(data, body...)
+}
+
+// Decodes a whole record, header included.
+func decodeDiskRecord(data []byte) (rec diskRecord, err error) {
+	if len(data) < diskRecordHeader {
+		return rec, errBadRecord
+	}
+	body := data[diskRecordHeader:]
+	if int(binary.BigEndian.Uint32(data)) != len(body) || binary.BigEndian.Uint32(data[4:]) != crc32.ChecksumIEEE(body) {
+		return rec, errBadRecord
+	}
+	keyLen, n := binary.Uvarint(body)
+	if n <= 0 || uint64(len(body)-n) < keyLen+9 {
+		return rec, errBadRecord
+	}
+	body = body[n:]
+	rec.key, body = string(body[:keyLen]), body[keyLen:]
+	rec.expires = int64(binary.BigEndian.Uint64(body))
+	rec.deleted = body[8] == 1
+	rec.value = body[9:]
+	return rec, nil
+}
+
+// Reads the next record, header included. Returns io.EOF if there are no
+// more, and io.ErrUnexpectedEOF if the last one is incomplete.
+func readDiskRecord(r *bufio.Reader) ([]byte, error) {
+	header, err := r.Peek(diskRecordHeader)
+	if err == io.EOF && len(header) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > diskCacheMaxRecord {
+		return nil, errBadRecord
+	}
+	data := make([]byte, diskRecordHeader+int(length))
+	if _, err = io.ReadFull(r, data); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+// Opens the DiskCache kept in the file at path, creating it if needed.
+// Returns ErrCacheLocked if another process has it open.
+func OpenDiskCache(path string) (*DiskCache, error) {
+	lock, err := LockCacheFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	c := &DiskCache{path: path, file: file, lock: lock, index: map[string]diskEntry{}}
+	if err = c.load(); err != nil {
+		file.Close()
+		lock.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Builds the index from the file.
+func (c *DiskCache) load() error {
+	info, err := c.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		c.size = int64(len(diskCacheMagic))
+		_, err = c.file.WriteAt([]byte(diskCacheMagic), 0)
+		return err
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(c.file, 0, info.Size()))
+	magic := make([]byte, len(diskCacheMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != diskCacheMagic {
+		return ErrNotDiskCache
+	}
+
+	now := time.Now().UnixNano()
+	offset := int64(len(magic))
+	for {
+		data, err := readDiskRecord(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// Written last, when the program stopped; nothing after it can
+			// be trusted
+			if err = c.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		entry := diskEntry{offset: offset, length: int64(len(data))}
+		offset += entry.length
+
+		rec, err := decodeDiskRecord(data)
+		if err != nil {
+			continue
+		}
+		c.drop(rec.key)
+		if !rec.deleted && (rec.expires == 0 || rec.expires > now) {
+			entry.expires = rec.expires
+			c.index[rec.key] = entry
+			c.live += entry.length
+		}
+	}
+	c.size = offset
+	return nil
+}
+
+func (c *DiskCache) Get(key string) (v interface{}, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.index[key]
+	if ok && entry.expires != 0 && entry.expires <= time.Now().UnixNano() {
+		c.drop(key)
+		ok = false
+	}
+	if ok {
+		var err error
+		if v, err = c.read(entry); err != nil {
+			c.delete(key)
+			ok = false
+		}
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return v, true
+}
+
+func (c *DiskCache) read(entry diskEntry) (v interface{}, err error) {
+	data := make([]byte, entry.length)
+	if _, err = c.file.ReadAt(data, entry.offset); err != nil {
+		return
+	}
+	rec, err := decodeDiskRecord(data)
+	if err != nil {
+		return
+	}
+	return decodeCacheValue(rec.value)
+}
+
+// Gob-encodes a value on its own, so that it can be decoded without the ones
+// encoded before it.
+func encodeCacheValue(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&v)
+	return buf.Bytes(), err
+}
+
+func decodeCacheValue(data []byte) (v interface{}, err error) {
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return
+}
+
+func (c *DiskCache) Set(key string, v interface{}, ttl time.Duration) {
+	rec := diskRecord{key: key}
+	if ttl > 0 {
+		rec.expires = time.Now().Add(ttl).UnixNano()
+	}
+	value, err := encodeCacheValue(v)
+	rec.value = value
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		c.fail(err)
+		c.delete(key)
+		return
+	}
+	c.drop(key)
+	if entry, ok := c.append(rec); ok {
+		c.index[key] = entry
+		c.live += entry.length
+	}
+	c.compactIfNeeded()
+}
+
+func (c *DiskCache) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.delete(key)
+	c.compactIfNeeded()
+}
+
+func (c *DiskCache) delete(key string) {
+	if _, ok := c.index[key]; ok {
+		c.drop(key)
+		c.append(diskRecord{key: key, deleted: true})
+	}
+}
+
+// Removes key from the index, leaving its record in the file as garbage.
+func (c *DiskCache) drop(key string) {
+	if entry, ok := c.index[key]; ok {
+		c.live -= entry.length
+		delete(c.index, key)
+	}
+}
+
+func (c *DiskCache) append(rec diskRecord) (entry diskEntry, ok bool) {
+	data := rec.encode()
+	if _, err := c.file.WriteAt(data, c.size); err != nil {
+		// Overwritten by the next record, or dropped when opened again
+		c.fail(err)
+		return entry, false
+	}
+	entry = diskEntry{offset: c.size, length: int64(len(data)), expires: rec.expires}
+	c.size += entry.length
+	return entry, true
+}
+
+func (c *DiskCache) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *DiskCache) compactIfNeeded() {
+	if c.size >= diskCacheMinCompact && c.live < c.size/2 {
+		if err := c.compact(); err != nil {
+			c.fail(err)
+		}
+	}
+}
+
+// Rewrites the file with only the entries in the index that haven't expired,
+// into a new file that replaces it once complete.
+func (c *DiskCache) compact() error {
+	info, err := c.file.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly after the rename
+
+	now := time.Now().UnixNano()
+	index := make(map[string]diskEntry, len(c.index))
+	offset := int64(len(diskCacheMagic))
+	w := bufio.NewWriter(tmp)
+	w.WriteString(diskCacheMagic)
+	for key, entry := range c.index {
+		if entry.expires != 0 && entry.expires <= now {
+			continue
+		}
+		data := make([]byte, entry.length)
+		if _, err = c.file.ReadAt(data, entry.offset); err != nil {
+			break
+		}
+		w.Write(data)
+		index[key] = diskEntry{offset: offset, length: entry.length, expires: entry.expires}
+		offset += entry.length
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(info.Mode())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// The new file is written to from now on
+	c.file.Close()
+	c.file, c.index, c.size = tmp, index, offset
+	c.live = offset - int64(len(diskCacheMagic))
+	return nil
+}
+
+func (c *DiskCache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CacheStats{Items: len(c.index), Hits: c.hits, Misses: c.misses}
+}
+
+func (c *DiskCache) Keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	keys := make([]string, 0, len(c.index))
+	for key := range c.index {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Flushes the file to disk. Returns the first error writing to it since the
+// last Sync, if any.
+func (c *DiskCache) Sync() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.sync()
+}
+
+func (c *DiskCache) sync() error {
+	err := c.err
+	c.err = nil
+	if syncErr := c.file.Sync(); err == nil {
+		err = syncErr
+	}
+	return err
+}
+
+// Syncs and closes the file. The DiskCache can't be used afterwards.
+func (c *DiskCache) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	err := c.sync()
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := c.lock.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
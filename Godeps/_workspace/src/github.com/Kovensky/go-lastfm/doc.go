@@ -1,5 +1,31 @@
 // Implements a simple http://last.fm API client library.
 //
-// The library currently doesn't support authentication, so it only implements
-// a few queries that are useful without authentication.
+// Most methods only need an API key. Methods that authenticate users, or act
+// on their behalf with a Session, are signed, and need a LastFM created with
+// NewWithSecret; they are never cached.
+//
+// Every method takes a context.Context; canceling it, or reaching its
+// deadline, aborts the request. Requests also time out after DefaultTimeout.
+//
+// Results are cached in the Cache of LastFM, a MemoryCache unless changed;
+// LRUCache bounds the number of entries, and DiskCache keeps them in a file.
+// How long they're kept is up to the responses' headers, within the limits of
+// the CachePolicy and MethodCachePolicies of LastFM; Invalidate removes them
+// earlier.
+//
+// Identical calls made at the same time share a single request and its
+// result; CoalescedRequests tells how many requests that saved.
+//
+// Responses are requested in XML, unless the Format field of LastFM is set to
+// JSON; the results are the same with either.
+//
+// Requests are limited to DefaultRate per second by the Limiter of LastFM, and
+// the ones that fail temporarily are retried as its RetryPolicy allows; set
+// its Observer to know when that happens.
+//
+// Errors reported by Last.fm are returned as *LastFMError, which errors.Is
+// matches against its ErrorCode. Requests that fail otherwise return a
+// *RequestError, *HTTPError or *DecodeError, except when the context ends, in
+// which case its error is returned. IsNotFound, IsRateLimited and IsTemporary
+// tell the common cases apart.
 package lastfm
@@ -0,0 +1,165 @@
+package lastfm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// An error code returned by Last.fm. Codes are also errors, so they can be
+// compared against any error returned by this package with errors.Is:
+//
+//	if errors.Is(err, lastfm.ErrInvalidSessionKey) {
+//	    ...
+//	}
+//
+// See http://www.last.fm/api/errorcodes.
+type ErrorCode int
+
+const (
+	ErrInvalidService         ErrorCode = 2  // This service does not exist
+	ErrInvalidMethod          ErrorCode = 3  // No method with that name in this package
+	ErrAuthenticationFailed   ErrorCode = 4  // You do not have permissions to access the service
+	ErrInvalidFormat          ErrorCode = 5  // This service doesn't exist in that format
+	ErrInvalidParameters      ErrorCode = 6  // Your request is missing a required parameter, or names something that doesn't exist
+	ErrInvalidResource        ErrorCode = 7  // Invalid resource specified
+	ErrOperationFailed        ErrorCode = 8  // Something else went wrong
+	ErrInvalidSessionKey      ErrorCode = 9  // Please re-authenticate
+	ErrInvalidAPIKey          ErrorCode = 10 // You must be granted a valid key by last.fm
+	ErrServiceOffline         ErrorCode = 11 // This service is temporarily offline
+	ErrSubscribersOnly        ErrorCode = 12 // This station is only available to paid last.fm subscribers
+	ErrInvalidMethodSignature ErrorCode = 13 // Invalid method signature supplied
+	ErrUnauthorizedToken      ErrorCode = 14 // This token has not been authorized
+	ErrTokenExpired           ErrorCode = 15 // This token has expired
+	ErrTemporarilyUnavailable ErrorCode = 16 // The service is temporarily unavailable, please try again
+	ErrLoginRequired          ErrorCode = 17 // User requires to be logged in
+	ErrTrialExpired           ErrorCode = 18 // This user has no free radio plays left
+	ErrNotEnoughContent       ErrorCode = 20 // There is not enough content to play this station
+	ErrNotEnoughMembers       ErrorCode = 21 // This group does not have enough members for radio
+	ErrNotEnoughFans          ErrorCode = 22 // This artist does not have enough fans for radio
+	ErrNotEnoughNeighbours    ErrorCode = 23 // There are not enough neighbours for radio
+	ErrNoPeakRadio            ErrorCode = 24 // This user is not allowed to listen to radio during peak usage
+	ErrRadioNotFound          ErrorCode = 25 // Radio station not found
+	ErrAPIKeySuspended        ErrorCode = 26 // This application is not allowed to make requests to the web services
+	ErrDeprecated             ErrorCode = 27 // This type of request is no longer supported
+	ErrRateLimitExceeded      ErrorCode = 29 // Your IP has made too many requests in a short period
+)
+
+var errorCodeStringMap = map[ErrorCode]string{
+	ErrInvalidService:         "invalid service",
+	ErrInvalidMethod:          "invalid method",
+	ErrAuthenticationFailed:   "authentication failed",
+	ErrInvalidFormat:          "invalid format",
+	ErrInvalidParameters:      "invalid parameters",
+	ErrInvalidResource:        "invalid resource",
+	ErrOperationFailed:        "operation failed",
+	ErrInvalidSessionKey:      "invalid session key",
+	ErrInvalidAPIKey:          "invalid API key",
+	ErrServiceOffline:         "service offline",
+	ErrSubscribersOnly:        "subscribers only",
+	ErrInvalidMethodSignature: "invalid method signature",
+	ErrUnauthorizedToken:      "unauthorized token",
+	ErrTokenExpired:           "token expired",
+	ErrTemporarilyUnavailable: "temporarily unavailable",
+	ErrLoginRequired:          "login required",
+	ErrTrialExpired:           "trial expired",
+	ErrNotEnoughContent:       "not enough content",
+	ErrNotEnoughMembers:       "not enough members",
+	ErrNotEnoughFans:          "not enough fans",
+	ErrNotEnoughNeighbours:    "not enough neighbours",
+	ErrNoPeakRadio:            "no peak radio",
+	ErrRadioNotFound:          "radio not found",
+	ErrAPIKeySuspended:        "API key suspended",
+	ErrDeprecated:             "deprecated",
+	ErrRateLimitExceeded:      "rate limit exceeded",
+}
+
+func (c ErrorCode) String() string {
+	if s, ok := errorCodeStringMap[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("error code %d", int(c))
+}
+
+func (c ErrorCode) Error() string {
+	return "lastfm: " + c.String()
+}
+
+// An error reported by Last.fm. Message is Last.fm's own description of it,
+// which is often more specific than its Code, but not meant to be compared.
+type LastFMError struct {
+	error
+	Code    ErrorCode `xml:"code,attr"`
+	Message string    `xml:",chardata"`
+}
+
+func (e *LastFMError) Error() string {
+	return strings.Trim(e.Message, "\n ")
+}
+
+// Reports whether target is e's Code, or a *LastFMError with the same Code.
+func (e *LastFMError) Is(target error) bool {
+	switch t := target.(type) {
+	case ErrorCode:
+		return e.Code == t
+	case *LastFMError:
+		return e.Code == t.Code
+	}
+	return false
+}
+
+// Lets errors.As pick out the ErrorCode of a *LastFMError.
+func (e *LastFMError) As(target any) bool {
+	if code, ok := target.(*ErrorCode); ok {
+		*code = e.Code
+		return true
+	}
+	return false
+}
+
+// Returns whether err means that the user, artist, album or track a request
+// was about doesn't exist.
+//
+// Last.fm reports those as ErrInvalidParameters, as they are parameters that
+// don't name anything; as the methods of LastFM always send the parameters
+// Last.fm requires, that's what the code means for them.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrInvalidParameters) || errors.Is(err, ErrInvalidResource)
+}
+
+// Returns whether a request failed because too many requests were made,
+// either according to Last.fm or to the RateLimiter.
+func IsRateLimited(err error) bool {
+	var httpErr *HTTPError
+	switch {
+	case errors.Is(err, ErrRateLimitExceeded), errors.Is(err, ErrRateLimited):
+		return true
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode == 429
+	}
+	return false
+}
+
+// Returns whether a request failed because Last.fm was unavailable, so that
+// trying again later may work.
+func IsTemporary(err error) bool {
+	var (
+		lfmErr  *LastFMError
+		httpErr *HTTPError
+		reqErr  *RequestError
+	)
+	switch {
+	case IsRateLimited(err):
+		return true
+	case errors.As(err, &lfmErr):
+		switch lfmErr.Code {
+		case ErrOperationFailed, ErrServiceOffline, ErrTemporarilyUnavailable:
+			return true
+		}
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode >= 500
+	case errors.As(err, &reqErr):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package lastfm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Kovensky/go-lastfm"
+)
+
+func TestErrorCodes(T *testing.T) {
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	_, err := lfm.GetUserNeighbours(context.Background(), "Nobody Here", 1)
+
+	if !errors.Is(err, lastfm.ErrInvalidParameters) {
+		T.Errorf("Expected %v -- Got %#v", lastfm.ErrInvalidParameters, err)
+	}
+	if errors.Is(err, lastfm.ErrInvalidSessionKey) {
+		T.Errorf("Expected not to be %v", lastfm.ErrInvalidSessionKey)
+	}
+	if !errors.Is(err, &lastfm.LastFMError{Code: lastfm.ErrInvalidParameters}) {
+		T.Error("Expected to match a LastFMError with the same code")
+	}
+
+	var code lastfm.ErrorCode
+	if errors.As(fmt.Errorf("wrapped: %w", err), &code) {
+		Expect(T, "code", lastfm.ErrInvalidParameters, code)
+	} else {
+		T.Error("Expected errors.As to find the code")
+	}
+
+	Expect(T, "IsNotFound", true, lastfm.IsNotFound(err))
+	Expect(T, "IsTemporary", false, lastfm.IsTemporary(err))
+	Expect(T, "code string", "rate limit exceeded", lastfm.ErrRateLimitExceeded.String())
+	Expect(T, "unknown code string", "error code 1", lastfm.ErrorCode(1).String())
+}
+
+func TestErrorHelpers(T *testing.T) {
+	for _, c := range []struct {
+		err                              error
+		notFound, rateLimited, temporary bool
+	}{
+		{&lastfm.LastFMError{Code: lastfm.ErrInvalidResource}, true, false, false},
+		{&lastfm.LastFMError{Code: lastfm.ErrRateLimitExceeded}, false, true, true},
+		{&lastfm.LastFMError{Code: lastfm.ErrServiceOffline}, false, false, true},
+		{&lastfm.LastFMError{Code: lastfm.ErrInvalidSessionKey}, false, false, false},
+		{&lastfm.HTTPError{StatusCode: 429}, false, true, true},
+		{&lastfm.HTTPError{StatusCode: 503}, false, false, true},
+		{&lastfm.HTTPError{StatusCode: 404}, false, false, false},
+		{&lastfm.RequestError{Err: errors.New("connection reset")}, false, false, true},
+		{lastfm.ErrRateLimited, false, true, true},
+		{context.Canceled, false, false, false},
+	} {
+		Expect(T, fmt.Sprintf("IsNotFound(%#v)", c.err), c.notFound, lastfm.IsNotFound(c.err))
+		Expect(T, fmt.Sprintf("IsRateLimited(%#v)", c.err), c.rateLimited, lastfm.IsRateLimited(c.err))
+		Expect(T, fmt.Sprintf("IsTemporary(%#v)", c.err), c.temporary, lastfm.IsTemporary(c.err))
+	}
+}
@@ -0,0 +1,84 @@
+package lastfm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Coalesces identical calls made at the same time, so that they share a
+// single request and its result.
+type flightGroup struct {
+	mutex     sync.Mutex
+	flights   map[string]*flight
+	coalesced atomic.Uint64
+}
+
+type flight struct {
+	done chan struct{}
+	v    any
+	err  error
+}
+
+var errFlightAborted = errors.New("lastfm: coalesced request aborted")
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: map[string]*flight{}}
+}
+
+// Calls fn, unless a call with the same key is in flight, in which case it
+// waits for that one to finish and returns its result instead.
+//
+// If the call in flight fails because its own context ended, and ctx didn't,
+// fn is called again for the ones waiting on it.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (any, error)) (any, error) {
+	for {
+		g.mutex.Lock()
+		f, ok := g.flights[key]
+		if !ok {
+			f = &flight{done: make(chan struct{}), err: errFlightAborted}
+			g.flights[key] = f
+			g.mutex.Unlock()
+			return g.run(key, f, fn)
+		}
+		g.mutex.Unlock()
+
+		g.coalesced.Add(1)
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if isContextError(f.err) && ctx.Err() == nil {
+			// saved nothing after all
+			g.coalesced.Add(^uint64(0))
+			continue
+		}
+		return f.v, f.err
+	}
+}
+
+func (g *flightGroup) run(key string, f *flight, fn func() (any, error)) (any, error) {
+	defer func() {
+		g.mutex.Lock()
+		delete(g.flights, key)
+		g.mutex.Unlock()
+		close(f.done)
+	}()
+	f.v, f.err = fn()
+	return f.v, f.err
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Returns how many calls shared the request of an identical call made at the
+// same time, instead of making their own.
+func (lfm *LastFM) CoalescedRequests() uint64 {
+	if lfm.flights == nil {
+		return 0
+	}
+	return lfm.flights.coalesced.Load()
+}
@@ -0,0 +1,115 @@
+package lastfm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Holds every request until released, then answers it with recentBody, which
+// isn't cached as it has no caching headers.
+type blockingGetter struct {
+	release  chan struct{}
+	requests atomic.Int32
+}
+
+func (g *blockingGetter) Do(req *http.Request) (*http.Response, error) {
+	g.requests.Add(1)
+	select {
+	case <-g.release:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	return &http.Response{StatusCode: 200, Status: "200 OK", Body: io.NopCloser(strings.NewReader(recentBody))}, nil
+}
+
+// Waits for cond to be true, or fails the test after a second.
+func waitFor(T *testing.T, what string, cond func() bool) {
+	for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			T.Fatal("Timed out waiting for", what)
+		}
+	}
+}
+
+func TestCall_Coalesced(T *testing.T) {
+	g := &blockingGetter{release: make(chan struct{})}
+	lfm := New("key")
+	lfm.getter = g
+
+	const callers = 5
+	var wg sync.WaitGroup
+	results := make([]*RecentTracks, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if results[i], err = lfm.GetRecentTracks(context.Background(), "Kovensky", 1); err != nil {
+				T.Error(err)
+			}
+		}(i)
+	}
+	waitFor(T, "the calls to be coalesced", func() bool { return lfm.CoalescedRequests() == callers-1 })
+	close(g.release)
+	wg.Wait()
+
+	if n := g.requests.Load(); n != 1 {
+		T.Errorf("Expected 1 request -- Got %d", n)
+	}
+	for i, r := range results {
+		if r != results[0] {
+			T.Errorf("Expected caller %d to get the shared result -- Got %p, not %p", i, r, results[0])
+		}
+	}
+
+	// Nothing in flight anymore, and nothing cached
+	g.release = make(chan struct{})
+	close(g.release)
+	if _, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1); err != nil {
+		T.Fatal(err)
+	}
+	if n := g.requests.Load(); n != 2 {
+		T.Errorf("Expected a new request -- Got %d in total", n)
+	}
+}
+
+func TestCall_CoalescedCanceled(T *testing.T) {
+	g := &blockingGetter{release: make(chan struct{})}
+	lfm := New("key")
+	lfm.getter = g
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := lfm.GetRecentTracks(ctx, "Kovensky", 1)
+		first <- err
+	}()
+	waitFor(T, "the first request", func() bool { return g.requests.Load() == 1 })
+
+	second := make(chan error)
+	go func() {
+		_, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1)
+		second <- err
+	}()
+	waitFor(T, "the second call to be coalesced", func() bool { return lfm.CoalescedRequests() == 1 })
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		T.Errorf("Expected context.Canceled -- Got %v", err)
+	}
+	// The second call makes its own request instead
+	waitFor(T, "the second request", func() bool { return g.requests.Load() == 2 })
+	close(g.release)
+	if err := <-second; err != nil {
+		T.Errorf("Expected the second call to succeed -- Got %v", err)
+	}
+	if n := lfm.CoalescedRequests(); n != 0 {
+		T.Errorf("Expected no coalesced requests -- Got %d", n)
+	}
+}
@@ -0,0 +1,173 @@
+package lastfm
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The format the API servers are asked to answer in. Both are decoded into
+// the same structs, with the same results.
+type Format int
+
+const (
+	XML  Format = iota // The default
+	JSON               // Smaller responses, faster to decode
+)
+
+func (f Format) String() string {
+	if f == JSON {
+		return "json"
+	}
+	return "xml"
+}
+
+// Decodes a response in the format of lfm into status.
+func (lfm *LastFM) decode(body io.Reader, status *lfmStatus) error {
+	if lfm.Format == JSON {
+		return decodeJSON(body, status)
+	}
+	return xml.NewDecoder(body).Decode(status)
+}
+
+// Last.fm's JSON responses are a translation of the XML ones: elements become
+// keys, repeated elements become arrays (but a single one is just the object),
+// attributes go in an "@attr" object, and elements that only have text become
+// strings, or, if they also have attributes, objects with the text in "#text"
+// next to them. The translation is undone here, so that the xml struct tags
+// decode both formats.
+func decodeJSON(r io.Reader, status *lfmStatus) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var root map[string]interface{}
+	if err := dec.Decode(&root); err != nil {
+		return err
+	}
+
+	// {"error": 6, "message": "User not found"}
+	if code, ok := root["error"]; ok {
+		n, err := strconv.Atoi(jsonText(code))
+		if err != nil {
+			return fmt.Errorf("lastfm: invalid error code %q", jsonText(code))
+		}
+		status.Status = "failed"
+		status.Error.Code = ErrorCode(n)
+		status.Error.Message = jsonText(root["message"])
+		return nil
+	}
+
+	start := xml.StartElement{
+		Name: xml.Name{Local: "lfm"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "status"}, Value: "ok"}},
+	}
+	tokens := tokenList{start}
+	for _, key := range sortedKeys(root) {
+		tokens = appendJSONElement(tokens, key, root[key])
+	}
+	tokens = append(tokens, start.End())
+	return xml.NewTokenDecoder(&tokens).Decode(status)
+}
+
+// Appends the XML tokens of the element encoded as v in JSON.
+func appendJSONElement(tokens tokenList, name string, v interface{}) tokenList {
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			tokens = appendJSONElement(tokens, name, item)
+		}
+		return tokens
+	}
+
+	start := xml.StartElement{Name: jsonName(name)}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		// Empty lists are sometimes sent as a string with the whitespace
+		// that was inside the element, which makes no difference here
+		tokens = append(tokens, start)
+		if v != nil {
+			tokens = append(tokens, xml.CharData(jsonText(v)))
+		}
+		return append(tokens, start.End())
+	}
+
+	text, hasText := obj["#text"]
+	if attrs, ok := obj["@attr"].(map[string]interface{}); ok {
+		for _, key := range sortedKeys(attrs) {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: key}, Value: jsonText(attrs[key])})
+		}
+	}
+	var children tokenList
+	for _, key := range sortedKeys(obj) {
+		value := obj[key]
+		switch {
+		case key == "@attr" || key == "#text":
+		case hasText && isJSONScalar(value):
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: key}, Value: jsonText(value)})
+		default:
+			children = appendJSONElement(children, key, value)
+		}
+	}
+
+	tokens = append(tokens, start)
+	if hasText {
+		tokens = append(tokens, xml.CharData(jsonText(text)))
+	}
+	tokens = append(tokens, children...)
+	return append(tokens, start.End())
+}
+
+// Returns the XML name of a key; search results have keys with namespace
+// prefixes, such as "opensearch:totalResults".
+func jsonName(key string) xml.Name {
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		return xml.Name{Space: key[:i], Local: key[i+1:]}
+	}
+	return xml.Name{Local: key}
+}
+
+func isJSONScalar(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
+// Returns the text of a JSON scalar; numbers are kept as they were sent.
+func jsonText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Feeds a list of tokens to an xml.Decoder.
+type tokenList []xml.Token
+
+func (l *tokenList) Token() (xml.Token, error) {
+	if len(*l) == 0 {
+		return nil, io.EOF
+	}
+	t := (*l)[0]
+	*l = (*l)[1:]
+	return t, nil
+}
@@ -0,0 +1,163 @@
+package lastfm_test
+
+import (
+	"context"
+	"github.com/Kovensky/go-lastfm"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Runs query against the XML and the JSON fixtures, and checks that both give
+// the same results.
+func expectSameFormats(T *testing.T, query func(lfm lastfm.LastFM) (interface{}, error)) {
+	xmlLFM := lastfm.Mock(lastfm.NewWithSecret("4c563adf68bc357a4570d3e7986f6481", "secret"))
+	jsonLFM := lastfm.Mock(lastfm.NewWithSecret("4c563adf68bc357a4570d3e7986f6481", "secret"))
+	jsonLFM.Format = lastfm.JSON
+
+	xmlResult, xmlErr := query(xmlLFM)
+	jsonResult, jsonErr := query(jsonLFM)
+	if !reflect.DeepEqual(xmlErr, jsonErr) {
+		T.Errorf("Expected the same error -- Got %v (XML) and %v (JSON)", xmlErr, jsonErr)
+	}
+	if !reflect.DeepEqual(xmlResult, jsonResult) {
+		T.Errorf("Expected the same result -- Got\n%#v (XML)\n%#v (JSON)", xmlResult, jsonResult)
+	}
+}
+
+func TestFormats_GetRecentTracks(T *testing.T) {
+	T.Parallel()
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		tracks, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1)
+		if err == nil && tracks.NowPlaying != &tracks.Tracks[0] {
+			T.Errorf("Expected now playing track %p -- Got %p (%v)", &tracks.Tracks[0], tracks.NowPlaying, lfm.Format)
+		}
+		return tracks, err
+	})
+}
+
+func TestFormats_CompareTaste(T *testing.T) {
+	T.Parallel()
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.CompareTaste(context.Background(), "Kovensky", "D4RK-PH0ENIX")
+	})
+}
+
+func TestFormats_GetUserNeighbours(T *testing.T) {
+	T.Parallel()
+	// a single neighbour is not sent as a list in JSON
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserNeighbours(context.Background(), "Kovensky", 1)
+	})
+}
+
+func TestFormats_GetUserNeighbours_Error(T *testing.T) {
+	T.Parallel()
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		n, err := lfm.GetUserNeighbours(context.Background(), "Nobody Here", 1)
+		if lfmErr, ok := err.(*lastfm.LastFMError); !ok || lfmErr.Code != 6 {
+			T.Errorf("Expected error code 6 -- Got %#v (%v)", err, lfm.Format)
+		}
+		return n, err
+	})
+}
+
+func TestFormats_GetUserTopArtists(T *testing.T) {
+	T.Parallel()
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserTopArtists(context.Background(), "Kovensky", lastfm.Overall, 1)
+	})
+}
+
+func TestFormats_UserMethods(T *testing.T) {
+	T.Parallel()
+	ctx := context.Background()
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserTopTracks(ctx, "Kovensky", lastfm.Overall, 1, 2)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserTopAlbums(ctx, "Kovensky", lastfm.OneWeek, 1, 2)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserTopTags(ctx, "Kovensky", 2)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserLovedTracks(ctx, "Kovensky", 1, 2)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserInfo(ctx, "Kovensky")
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserFriends(ctx, "Kovensky", 1, 2)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserWeeklyChartList(ctx, "Kovensky")
+	})
+	week := lastfm.ChartRange{From: time.Unix(1367755200, 0), To: time.Unix(1368360000, 0)}
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserWeeklyArtistChart(ctx, "Kovensky", lastfm.ChartRange{})
+	})
+	// a single album is not sent as a list in JSON
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserWeeklyAlbumChart(ctx, "Kovensky", week)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetUserWeeklyTrackChart(ctx, "Kovensky", week)
+	})
+}
+
+func TestFormats_CatalogueMethods(T *testing.T) {
+	T.Parallel()
+	ctx := context.Background()
+	daftPunk := lastfm.Artist{Name: "Daft Punk"}
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetArtistInfo(ctx, daftPunk, "Kovensky", false)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetSimilarArtists(ctx, lastfm.Artist{MBID: "056e4f3e-d505-4dad-8ec1-d04f521cbb56"}, 2, false)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetArtistTopTracks(ctx, daftPunk, 1, 2, true)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetAlbumInfo(ctx, lastfm.AlbumInfo{Artist: "Daft Punk", Name: "Discovery"}, "", false)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetSimilarTracks(ctx, lastfm.Track{MBID: "29b45fae-fc32-43c0-ab74-052842458315"}, 2, false)
+	})
+	// the opensearch elements are sent with their prefix in JSON
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.SearchArtists(ctx, "Daft Punk", 1, 2)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.SearchAlbums(ctx, "Discovery", 1, 2)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.SearchTracks(ctx, "Motherboard", "Daft Punk", 1, 2)
+	})
+}
+
+func TestFormats_GetTrackInfo(T *testing.T) {
+	T.Parallel()
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetTrackInfo(
+			context.Background(), lastfm.Track{MBID: "29b45fae-fc32-43c0-ab74-052842458315"}, "", false)
+	})
+	// has an empty tag list, which is sent as a string in JSON
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetTrackInfo(
+			context.Background(), lastfm.Track{Artist: lastfm.Artist{Name: "Daft Punk"}, Name: "Motherboard"},
+			"Kovensky", false)
+	})
+}
+
+func TestFormats_GetTopTags(T *testing.T) {
+	T.Parallel()
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetTrackTopTags(
+			context.Background(), lastfm.Track{MBID: "48fa1cab-5250-4767-bbdf-14e0ef563d11"}, false)
+	})
+	expectSameFormats(T, func(lfm lastfm.LastFM) (interface{}, error) {
+		return lfm.GetArtistTopTags(context.Background(), lastfm.Artist{Name: "Daft Punk"}, false)
+	})
+}
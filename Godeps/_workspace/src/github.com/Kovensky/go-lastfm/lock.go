@@ -0,0 +1,26 @@
+package lastfm
+
+import (
+	"errors"
+	"io"
+)
+
+// Returned by LockCacheFile and OpenDiskCache when another process holds the
+// lock.
+var ErrCacheLocked = errors.New("lastfm: the cache file is in use by another process")
+
+// Locks the cache file at path, so that other processes don't use it at the
+// same time, until the returned io.Closer is closed. Returns ErrCacheLocked if
+// another process holds the lock.
+//
+// The lock is held on path+".lock", which is created if needed, so that it
+// isn't lost when the file is replaced, as when it's saved with a rename.
+// Locking is advisory, and only supported on Unix systems; elsewhere, it
+// always succeeds.
+func LockCacheFile(path string) (io.Closer, error) {
+	file, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package lastfm
+
+import "os"
+
+// Opens the file at path, creating it if needed; it isn't locked.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package lastfm
+
+import (
+	"os"
+	"syscall"
+)
+
+// Opens the file at path, creating it if needed, and locks it until it's
+// closed.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			err = ErrCacheLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,133 @@
+package lastfm
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// A Cache kept in memory that holds up to a number of entries. When it's full,
+// the least recently used entry is removed to make room for new ones.
+type LRUCache struct {
+	mutex     sync.Mutex
+	maxItems  int
+	items     map[string]*list.Element // of *lruEntry
+	order     *list.List               // The most recently used first
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type lruEntry struct {
+	key string
+	CacheItem
+}
+
+// Creates an empty LRUCache that holds up to maxItems entries.
+func NewLRUCache(maxItems int) *LRUCache {
+	return &LRUCache{maxItems: maxItems, items: map[string]*list.Element{}, order: list.New()}
+}
+
+func (c *LRUCache) Get(key string) (v interface{}, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.items[key]
+	if ok && e.Value.(*lruEntry).expired(time.Now()) {
+		c.remove(e)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).Value, true
+}
+
+func (c *LRUCache) Set(key string, v interface{}, ttl time.Duration) {
+	item := CacheItem{Value: v}
+	if ttl > 0 {
+		item.Expires = time.Now().Add(ttl)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry).CacheItem = item
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, CacheItem: item})
+	for len(c.items) > c.maxItems {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *LRUCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.items, e.Value.(*lruEntry).key)
+}
+
+func (c *LRUCache) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+}
+
+func (c *LRUCache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CacheStats{Items: len(c.items), Hits: c.hits, Misses: c.misses, Evictions: c.evictions}
+}
+
+func (c *LRUCache) Keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	keys := make([]string, 0, len(c.items))
+	for key := range c.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (c *LRUCache) Items() map[string]CacheItem {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	items := map[string]CacheItem{}
+	for key, e := range c.items {
+		if entry := e.Value.(*lruEntry); !entry.expired(now) {
+			items[key] = entry.CacheItem
+		}
+	}
+	return items
+}
+
+// The entries are added as if they were used least recently, so that when
+// there are too many, the ones already in the cache are kept.
+func (c *LRUCache) AddItems(items map[string]CacheItem) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for key, item := range items {
+		if item.expired(now) {
+			continue
+		}
+		if e, ok := c.items[key]; ok {
+			e.Value.(*lruEntry).CacheItem = item
+			continue
+		}
+		if len(c.items) >= c.maxItems {
+			break
+		}
+		c.items[key] = c.order.PushBack(&lruEntry{key: key, CacheItem: item})
+	}
+}
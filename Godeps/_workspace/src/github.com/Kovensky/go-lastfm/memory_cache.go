@@ -0,0 +1,117 @@
+package lastfm
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Cache kept in memory, without a size limit. Expired entries are removed
+// by the first Set after each cleanup interval.
+type MemoryCache struct {
+	mutex           sync.RWMutex
+	items           map[string]CacheItem
+	cleanupInterval time.Duration
+	nextCleanup     time.Time
+	hits            atomic.Uint64
+	misses          atomic.Uint64
+}
+
+// Creates an empty MemoryCache. If cleanupInterval is 0 or less, expired
+// entries are only removed when replaced.
+func NewMemoryCache(cleanupInterval time.Duration) *MemoryCache {
+	return &MemoryCache{
+		items:           map[string]CacheItem{},
+		cleanupInterval: cleanupInterval,
+		nextCleanup:     time.Now().Add(cleanupInterval),
+	}
+}
+
+func (c *MemoryCache) Get(key string) (v interface{}, ok bool) {
+	c.mutex.RLock()
+	item, ok := c.items[key]
+	c.mutex.RUnlock()
+
+	if !ok || item.expired(time.Now()) {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return item.Value, true
+}
+
+func (c *MemoryCache) Set(key string, v interface{}, ttl time.Duration) {
+	now := time.Now()
+	item := CacheItem{Value: v}
+	if ttl > 0 {
+		item.Expires = now.Add(ttl)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.items[key] = item
+	c.cleanup(now)
+}
+
+// Removes the expired entries if the cleanup interval has passed. Must be
+// called with the lock held.
+func (c *MemoryCache) cleanup(now time.Time) {
+	if c.cleanupInterval <= 0 || now.Before(c.nextCleanup) {
+		return
+	}
+	for key, item := range c.items {
+		if item.expired(now) {
+			delete(c.items, key)
+		}
+	}
+	c.nextCleanup = now.Add(c.cleanupInterval)
+}
+
+func (c *MemoryCache) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.items, key)
+}
+
+func (c *MemoryCache) Stats() CacheStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return CacheStats{Items: len(c.items), Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+func (c *MemoryCache) Keys() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	keys := make([]string, 0, len(c.items))
+	for key := range c.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (c *MemoryCache) Items() map[string]CacheItem {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	now := time.Now()
+	items := map[string]CacheItem{}
+	for key, item := range c.items {
+		if !item.expired(now) {
+			items[key] = item
+		}
+	}
+	return items
+}
+
+func (c *MemoryCache) AddItems(items map[string]CacheItem) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for key, item := range items {
+		if !item.expired(now) {
+			c.items[key] = item
+		}
+	}
+}
@@ -11,16 +11,25 @@ import (
 
 type MockLastFM struct{}
 
-func (_ *MockLastFM) Get(uri string) (resp *http.Response, err error) {
-	u, _ := url.Parse(uri) // always valid: we only call it with URLs generated by url.URL{}.String()
-	fn := buildMockFilename(u.Query())
+func (_ *MockLastFM) Do(req *http.Request) (resp *http.Response, err error) {
+	if err = req.Context().Err(); err != nil {
+		return
+	}
+	if err = req.ParseForm(); err != nil {
+		return
+	}
+	fn := buildMockFilename(req.Form)
 	fh, err := os.Open(fn)
 
 	if err != nil && os.IsNotExist(err) {
-		fmt.Fprintln(os.Stderr, "Produce with: curl -o - '"+uri+"' > '"+fn+"'")
+		if req.Method == "POST" {
+			fmt.Fprintln(os.Stderr, "Produce with: curl -o - -d '"+req.PostForm.Encode()+"' '"+req.URL.String()+"' > '"+fn+"'")
+		} else {
+			fmt.Fprintln(os.Stderr, "Produce with: curl -o - '"+req.URL.String()+"' > '"+fn+"'")
+		}
 		return
 	}
-	resp = &http.Response{Body: fh} // doQuery only cares about the Body
+	resp = &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: fh}
 	return
 }
 
@@ -30,7 +39,7 @@ func buildMockFilename(v url.Values) string {
 
 	keys := make([]string, 0, len(v)-1)
 	for key, _ := range v {
-		if key == "method" || key == "api_key" {
+		if key == "method" || key == "api_key" || key == "api_sig" {
 			continue
 		}
 		keys = append(keys, key)
@@ -41,7 +50,11 @@ func buildMockFilename(v url.Values) string {
 	for _, key := range keys {
 		parts = append(parts, strings.Join([]string{key, strings.Replace(strings.Join(v[key], ","), " ", ".", -1)}, "="))
 	}
-	parts = append(parts, "xml")
+	if v.Get("format") == "json" {
+		parts = append(parts, "json")
+	} else {
+		parts = append(parts, "xml")
+	}
 
 	return "fixtures/" + strings.Join(parts, ".")
 }
@@ -0,0 +1,179 @@
+package lastfm
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Last.fm allows about 5 requests per second per API key.
+const (
+	DefaultRate  = 5.0
+	DefaultBurst = 5
+)
+
+// Returned when a request is dropped because the RateLimiter would make it
+// wait longer than its MaxWait.
+var ErrRateLimited = errors.New("lastfm: request dropped by the rate limiter")
+
+// Limits how often requests are sent, with a token bucket: it holds up to
+// Burst tokens, refilled at Rate per second, and every request takes one,
+// waiting for it if there are none left.
+//
+// A RateLimiter is safe for concurrent use, and can be shared by several
+// LastFM structs using the same API key.
+type RateLimiter struct {
+	Rate    float64       // Tokens added per second
+	Burst   int           // How many tokens the bucket holds
+	MaxWait time.Duration // Requests that would wait longer are dropped; 0 waits as long as the context allows
+
+	mutex  sync.Mutex
+	tokens float64   // Negative when requests are waiting for tokens
+	last   time.Time // When tokens was last updated
+}
+
+// Creates a RateLimiter that starts with a full bucket.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// Takes a token, waiting until it's available or ctx is done. Returns how long
+// it waited.
+func (l *RateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	l.mutex.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.Rate
+	if l.tokens > float64(l.Burst) {
+		l.tokens = float64(l.Burst)
+	}
+	l.last = now
+
+	var wait time.Duration
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.Rate * float64(time.Second))
+	}
+	if l.MaxWait > 0 && wait > l.MaxWait {
+		l.mutex.Unlock()
+		return 0, ErrRateLimited
+	}
+	if deadline, ok := ctx.Deadline(); ok && now.Add(wait).After(deadline) {
+		l.mutex.Unlock()
+		return 0, ErrRateLimited
+	}
+	// Taken now, so that later callers wait after this one
+	l.tokens--
+	l.mutex.Unlock()
+
+	if err := sleep(ctx, wait); err != nil {
+		l.mutex.Lock()
+		l.tokens++
+		l.mutex.Unlock()
+		return 0, err
+	}
+	return wait, nil
+}
+
+// Tells how failed requests are retried. The wait before each retry doubles,
+// starting at MinBackoff and up to MaxBackoff, and a random part of up to half
+// of it is taken away, so that requests that failed together aren't retried
+// together.
+type RetryPolicy struct {
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 3, MinBackoff: 500 * time.Millisecond, MaxBackoff: 10 * time.Second}
+
+// Returns how long to wait before the given retry, counting from 1.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d > 1 {
+		d -= time.Duration(rand.Int63n(int64(d / 2)))
+	}
+	return d
+}
+
+// Returns whether a request that failed with err may work if retried right
+// away: temporary errors other than ErrOperationFailed, which Last.fm also
+// uses for failures that last, and ErrRateLimited, which is the RateLimiter's
+// own doing.
+func retryable(err error) bool {
+	return IsTemporary(err) && !errors.Is(err, ErrOperationFailed) && !errors.Is(err, ErrRateLimited)
+}
+
+// What happened to a request, as told to a LastFM's Observer.
+type EventKind int
+
+const (
+	RequestDelayed EventKind = iota // Waited for the rate limiter
+	RequestRetried                  // Failed, and is going to be retried
+	RequestDropped                  // Not sent, or failed, because of the rate limiter or after the last retry
+)
+
+var eventKindStringMap = map[EventKind]string{
+	RequestDelayed: "delayed",
+	RequestRetried: "retried",
+	RequestDropped: "dropped",
+}
+
+func (k EventKind) String() string {
+	return eventKindStringMap[k]
+}
+
+type Event struct {
+	Kind   EventKind
+	Method string
+	Wait   time.Duration // How long the request waited or is going to wait
+	Retry  int           // The number of the retry, counting from 1, for RequestRetried
+	Err    error         // The error that made the request be retried or dropped
+}
+
+func (lfm *LastFM) observe(e Event) {
+	if lfm.Observer != nil {
+		lfm.Observer(e)
+	}
+}
+
+// Executes a request, after waiting for the rate limiter, and retries it as
+// the retry policy allows.
+func (lfm *LastFM) executeWithRetries(ctx context.Context, r *request) (status *lfmStatus, hdr http.Header, err error) {
+	for retry := 0; ; retry++ {
+		if lfm.Limiter != nil {
+			wait, err := lfm.Limiter.Wait(ctx)
+			if err == ErrRateLimited {
+				lfm.observe(Event{Kind: RequestDropped, Method: r.method, Err: err})
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if wait > 0 {
+				lfm.observe(Event{Kind: RequestDelayed, Method: r.method, Wait: wait})
+			}
+		}
+
+		status, hdr, err = lfm.execute(ctx, r)
+		if err == nil || !retryable(err) || ctx.Err() != nil {
+			return
+		}
+		if retry >= lfm.Retry.MaxRetries {
+			lfm.observe(Event{Kind: RequestDropped, Method: r.method, Err: err})
+			return
+		}
+
+		wait := lfm.Retry.backoff(retry + 1)
+		lfm.observe(Event{Kind: RequestRetried, Method: r.method, Wait: wait, Retry: retry + 1, Err: err})
+		if sleepErr := sleep(ctx, wait); sleepErr != nil {
+			return nil, hdr, sleepErr
+		}
+	}
+}
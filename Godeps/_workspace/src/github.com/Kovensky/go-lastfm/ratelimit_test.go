@@ -0,0 +1,156 @@
+package lastfm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Answers each request with the next of its responses, given as status and
+// body, or fails like a refused connection if the status is empty; the last
+// one is repeated.
+type sequenceGetter struct {
+	sync.Mutex
+	responses [][2]string
+	requests  int
+}
+
+func (g *sequenceGetter) Do(req *http.Request) (*http.Response, error) {
+	g.Lock()
+	defer g.Unlock()
+	r := g.responses[len(g.responses)-1]
+	if g.requests < len(g.responses) {
+		r = g.responses[g.requests]
+	}
+	g.requests++
+	if r[0] == "" {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: 200, Status: r[0], Body: io.NopCloser(strings.NewReader(r[1]))}, nil
+}
+
+const (
+	unavailableBody = `<lfm status="failed"><error code="16">There was a temporary error processing your request.</error></lfm>`
+	notFoundBody    = `<lfm status="failed"><error code="6">User not found</error></lfm>`
+	recentBody      = `<lfm status="ok"><recenttracks user="Kovensky" total="0"></recenttracks></lfm>`
+)
+
+func newSequenceLastFM(responses ...[2]string) (*sequenceGetter, *LastFM, *[]Event) {
+	g := &sequenceGetter{responses: responses}
+	lfm := New("key")
+	lfm.getter = g
+	lfm.Retry.MinBackoff = time.Millisecond
+	var events []Event
+	lfm.Observer = func(e Event) { events = append(events, e) }
+	return g, &lfm, &events
+}
+
+func TestRateLimiter(T *testing.T) {
+	l := NewRateLimiter(50, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		wait, err := l.Wait(context.Background())
+		if err != nil {
+			T.Fatal(err)
+		}
+		if i < 2 && wait != 0 {
+			T.Errorf("Expected request %d to use the burst -- Waited %v", i, wait)
+		}
+	}
+	// the third and fourth requests wait 20ms each
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		T.Errorf("Expected to wait for 40ms -- Waited %v", elapsed)
+	}
+
+	l.MaxWait = time.Millisecond
+	if _, err := l.Wait(context.Background()); err != ErrRateLimited {
+		T.Errorf("Expected the request to be dropped -- Got %v", err)
+	}
+}
+
+func TestRateLimiter_Canceled(T *testing.T) {
+	l := NewRateLimiter(1, 1)
+	l.Wait(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Wait(ctx); err != context.Canceled {
+		T.Errorf("Expected context.Canceled -- Got %v", err)
+	}
+	if l.tokens < -0.1 {
+		T.Errorf("Expected the token to be given back -- Got %v tokens", l.tokens)
+	}
+}
+
+func TestRetries(T *testing.T) {
+	g, lfm, events := newSequenceLastFM(
+		[2]string{"", ""},
+		[2]string{"503 Service Unavailable", unavailableBody},
+		[2]string{"200 OK", recentBody})
+	lfm.Limiter = nil
+
+	if _, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1); err != nil {
+		T.Fatal(err)
+	}
+	if g.requests != 3 || len(*events) != 2 {
+		T.Fatalf("Expected 3 requests and 2 retries -- Got %d requests and events %v", g.requests, *events)
+	}
+	for i, e := range *events {
+		if e.Kind != RequestRetried || e.Retry != i+1 || e.Method != "user.getRecentTracks" || e.Err == nil {
+			T.Errorf("Expected retry %d -- Got %+v", i+1, e)
+		}
+	}
+}
+
+func TestRetries_GivesUp(T *testing.T) {
+	g, lfm, events := newSequenceLastFM([2]string{"503 Service Unavailable", unavailableBody})
+	lfm.Limiter = nil
+
+	_, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1)
+	if lfmErr, ok := err.(*LastFMError); !ok || lfmErr.Code != 16 {
+		T.Errorf("Expected error code 16 -- Got %v", err)
+	}
+	if g.requests != 4 {
+		T.Errorf("Expected 4 requests -- Got %d", g.requests)
+	}
+	if last := (*events)[len(*events)-1]; last.Kind != RequestDropped || last.Err != err {
+		T.Errorf("Expected the request to be dropped -- Got %+v", last)
+	}
+}
+
+func TestRetries_NotRetryable(T *testing.T) {
+	g, lfm, events := newSequenceLastFM([2]string{"400 Bad Request", notFoundBody})
+
+	if _, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1); err == nil {
+		T.Fatal("Expected an error")
+	}
+	if g.requests != 1 || len(*events) != 0 {
+		T.Errorf("Expected 1 request and no events -- Got %d and %v", g.requests, *events)
+	}
+}
+
+func TestLimiterEvents(T *testing.T) {
+	_, lfm, events := newSequenceLastFM([2]string{"200 OK", recentBody})
+	lfm.Limiter = NewRateLimiter(100, 1)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := send(context.Background(), lfm, &request{method: "user.getRecentTracks"},
+			func(s *lfmStatus) *RecentTracks { return &s.RecentTracks }); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if len(*events) != 1 || (*events)[0].Kind != RequestDelayed || (*events)[0].Wait <= 0 {
+		T.Errorf("Expected the second request to be delayed -- Got %v", *events)
+	}
+
+	lfm.Limiter.MaxWait = time.Nanosecond
+	_, _, err := send(context.Background(), lfm, &request{method: "user.getRecentTracks"},
+		func(s *lfmStatus) *RecentTracks { return &s.RecentTracks })
+	if err != ErrRateLimited || (*events)[1].Kind != RequestDropped {
+		T.Errorf("Expected the third request to be dropped -- Got %v and %v", err, *events)
+	}
+}
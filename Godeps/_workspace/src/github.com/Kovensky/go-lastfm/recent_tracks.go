@@ -0,0 +1,135 @@
+package lastfm
+
+import (
+	"context"
+	"time"
+)
+
+// The most tracks Last.fm returns in a page of user.getRecentTracks.
+const MaxRecentTracksPerPage = 200
+
+// How long a RecentTracksIterator waits between pages by default.
+const DefaultPageDelay = 200 * time.Millisecond
+
+// Walks every page of a user's scrobbles, newest first. Use it like a
+// bufio.Scanner:
+//
+//	it := lfm.IterateRecentTracks("user", from, to)
+//	for it.Next(ctx) {
+//	    track := it.Track()
+//	    ...
+//	}
+//	if err := it.Err(); err != nil {
+//	    ...
+//	}
+//
+// The fields can be changed before the first call to Next.
+type RecentTracksIterator struct {
+	PerPage int           // Tracks requested per page; MaxRecentTracksPerPage by default
+	Delay   time.Duration // Waited between pages; DefaultPageDelay by default
+
+	// Known once Next returned true
+	Page       int // The page the current track is from
+	TotalPages int
+	Total      int // Scrobbles in the range, not counting the currently playing track
+
+	lfm      *LastFM
+	user     string
+	from, to time.Time
+
+	tracks      []Track
+	track       Track
+	sawPlaying  bool      // Whether the currently playing track was seen already
+	lastRequest time.Time // When the last page was requested
+	done        bool
+	err         error
+}
+
+// Returns an iterator over the user's scrobbles between from and to, either of
+// which can be zero to leave the range open. If to is zero, the currently
+// playing track, if any, comes first.
+//
+// Last.fm's pages are numbered from the newest scrobble, so new scrobbles made
+// while iterating shift the pages, and make some tracks appear twice; give a
+// to when that matters.
+func (lfm *LastFM) IterateRecentTracks(user string, from, to time.Time) *RecentTracksIterator {
+	return &RecentTracksIterator{
+		PerPage: MaxRecentTracksPerPage,
+		Delay:   DefaultPageDelay,
+		lfm:     lfm,
+		user:    user,
+		from:    from,
+		to:      to,
+	}
+}
+
+// Advances to the next track, fetching the next page when needed. Returns
+// false when there are no more tracks, or a page couldn't be fetched; Err
+// tells which.
+func (it *RecentTracksIterator) Next(ctx context.Context) bool {
+	for len(it.tracks) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.err = it.fetch(ctx, it.Page+1)
+	}
+	it.track, it.tracks = it.tracks[0], it.tracks[1:]
+	return true
+}
+
+// Returns the track Next advanced to.
+func (it *RecentTracksIterator) Track() Track {
+	return it.track
+}
+
+// Returns the error that stopped the iteration, or nil if it reached the end.
+func (it *RecentTracksIterator) Err() error {
+	return it.err
+}
+
+// Fetches a page, waiting Delay since the last one first, so that the pages
+// don't use up the LastFM's rate limit. Pages that fail temporarily, such as
+// when the rate limit is exceeded anyway, are retried as the LastFM's
+// RetryPolicy allows.
+func (it *RecentTracksIterator) fetch(ctx context.Context, page int) (err error) {
+	if !it.lastRequest.IsZero() {
+		if err = sleep(ctx, it.Delay-time.Since(it.lastRequest)); err != nil {
+			return
+		}
+	}
+	it.lastRequest = time.Now()
+
+	tracks, err := it.lfm.GetRecentTracksPage(ctx, it.user, it.from, it.to, page, it.PerPage)
+	if err != nil {
+		return
+	}
+
+	it.Page, it.TotalPages, it.Total = page, tracks.TotalPages, tracks.Total
+	it.done = page >= tracks.TotalPages
+	for _, track := range tracks.Tracks {
+		if track.NowPlaying {
+			// Every page has it
+			if it.sawPlaying || !it.to.IsZero() {
+				continue
+			}
+			it.sawPlaying = true
+		}
+		it.tracks = append(it.tracks, track)
+	}
+	return nil
+}
+
+// Waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
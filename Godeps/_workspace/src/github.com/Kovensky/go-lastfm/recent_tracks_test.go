@@ -0,0 +1,142 @@
+package lastfm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serves user.getRecentTracks for a user who is playing a track, and
+// scrobbled total tracks named after their number, from the newest.
+type fakeRecentTracks struct {
+	sync.Mutex
+	T         *testing.T
+	total     int
+	rateLimit map[string]bool // Pages that exceed the rate limit once
+	requests  []http.Header   // Query of every request, as headers for easy Get
+}
+
+func (f *fakeRecentTracks) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	q := req.URL.Query()
+	f.requests = append(f.requests, http.Header{"From": q["from"], "To": q["to"], "Page": q["page"]})
+	if q.Get("method") != "user.getRecentTracks" {
+		f.T.Errorf("Unexpected method %q", q.Get("method"))
+	}
+	if f.rateLimit[q.Get("page")] {
+		delete(f.rateLimit, q.Get("page"))
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `<lfm status="failed"><error code="29">Rate limit exceeded</error></lfm>`)
+		return
+	}
+
+	page, _ := strconv.Atoi(q.Get("page"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	pages := (f.total + limit - 1) / limit
+	fmt.Fprintf(w, `<lfm status="ok"><recenttracks user="Kovensky" page="%d" perPage="%d" totalPages="%d" total="%d">`,
+		page, limit, pages, f.total)
+	fmt.Fprint(w, `<track nowplaying="true"><artist><name>Daft Punk</name></artist><name>Playing</name></track>`)
+	for i := (page - 1) * limit; i < page*limit && i < f.total; i++ {
+		fmt.Fprintf(w, `<track><artist><name>Daft Punk</name></artist><name>%d</name><date uts="%d">date</date></track>`,
+			i, 1368900185-i*300)
+	}
+	fmt.Fprint(w, `</recenttracks></lfm>`)
+}
+
+func newFakeRecentTracks(T *testing.T, total int) (*fakeRecentTracks, *LastFM) {
+	f := &fakeRecentTracks{T: T, total: total, rateLimit: map[string]bool{}}
+	server := httptest.NewServer(f)
+	T.Cleanup(server.Close)
+	lfm := New("key")
+	lfm.getter = &testServerGetter{server}
+	lfm.Retry.MinBackoff = time.Millisecond
+	return f, &lfm
+}
+
+func TestIterateRecentTracks(T *testing.T) {
+	f, lfm := newFakeRecentTracks(T, 5)
+	f.rateLimit["2"] = true
+
+	it := lfm.IterateRecentTracks("Kovensky", time.Time{}, time.Time{})
+	it.PerPage, it.Delay = 2, time.Millisecond
+	var names []string
+	for it.Next(context.Background()) {
+		if it.TotalPages != 3 || it.Total != 5 {
+			T.Errorf("Expected 3 pages of 5 tracks -- Got %d of %d", it.TotalPages, it.Total)
+		}
+		names = append(names, it.Track().Name)
+	}
+	if err := it.Err(); err != nil {
+		T.Fatal(err)
+	}
+
+	if expected := "[Playing 0 1 2 3 4]"; fmt.Sprint(names) != expected {
+		T.Errorf("Expected the tracks %s -- Got %v", expected, names)
+	}
+	if len(f.requests) != 4 || it.Page != 3 {
+		T.Errorf("Expected 3 pages in 4 requests -- Got page %d in %d", it.Page, len(f.requests))
+	}
+}
+
+func TestIterateRecentTracks_Range(T *testing.T) {
+	f, lfm := newFakeRecentTracks(T, 3)
+	from, to := time.Unix(1368800000, 0), time.Unix(1368900000, 0)
+
+	it := lfm.IterateRecentTracks("Kovensky", from, to)
+	var names []string
+	for it.Next(context.Background()) {
+		names = append(names, it.Track().Name)
+	}
+	if err := it.Err(); err != nil {
+		T.Fatal(err)
+	}
+
+	if expected := "[0 1 2]"; fmt.Sprint(names) != expected {
+		T.Errorf("Expected the tracks %s, without the one playing -- Got %v", expected, names)
+	}
+	if r := f.requests[0]; r.Get("From") != "1368800000" || r.Get("To") != "1368900000" {
+		T.Errorf("Expected the range to be sent -- Got from %q to %q", r.Get("From"), r.Get("To"))
+	}
+}
+
+func TestIterateRecentTracks_GivesUp(T *testing.T) {
+	f, lfm := newFakeRecentTracks(T, 5)
+	f.rateLimit["1"] = true
+
+	lfm.Retry.MaxRetries = 0
+	it := lfm.IterateRecentTracks("Kovensky", time.Time{}, time.Time{})
+	if it.Next(context.Background()) {
+		T.Fatal("Expected no tracks")
+	}
+	var lfmErr *LastFMError
+	if !errors.As(it.Err(), &lfmErr) || lfmErr.Code != 29 {
+		T.Errorf("Expected error code 29 -- Got %v", it.Err())
+	}
+}
+
+func TestIterateRecentTracks_Canceled(T *testing.T) {
+	_, lfm := newFakeRecentTracks(T, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	it := lfm.IterateRecentTracks("Kovensky", time.Time{}, time.Time{})
+	it.PerPage, it.Delay = 2, time.Hour
+	if !it.Next(ctx) {
+		T.Fatal(it.Err())
+	}
+	cancel()
+	for it.Next(ctx) {
+	}
+	if it.Err() != context.Canceled {
+		T.Errorf("Expected context.Canceled -- Got %v", it.Err())
+	}
+	if it.Page != 1 {
+		T.Errorf("Expected to stop after the first page -- Got page %d", it.Page)
+	}
+}
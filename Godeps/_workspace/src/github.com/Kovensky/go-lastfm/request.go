@@ -0,0 +1,156 @@
+package lastfm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Returned when a request couldn't be sent, or its response couldn't be
+// read. Err doesn't include the request's URL, as that contains the API key.
+type RequestError struct {
+	Method string
+	Err    error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("lastfm: %s: %v", e.Method, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when the servers answer with an HTTP error that doesn't carry a
+// Last.fm error, usually when they're overloaded.
+type HTTPError struct {
+	Method     string
+	StatusCode int
+	Status     string // e.g. "503 Service Unavailable"
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("lastfm: %s: %s", e.Method, e.Status)
+}
+
+// Returned when a response can't be decoded.
+type DecodeError struct {
+	Method string
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("lastfm: %s: invalid response: %v", e.Method, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Calls an API method and returns its result, which result picks out of the
+// decoded response. Results are cached as the response's headers allow, and
+// a cached result is returned without making a request. Last.fm errors are
+// cached too, and returned as *LastFMError.
+//
+// Identical calls made while one is in flight wait for it and share its
+// result, instead of making their own requests.
+func call[T any](ctx context.Context, lfm *LastFM, method string, query map[string]string, result func(*lfmStatus) *T) (*T, error) {
+	if data, err := lfm.cacheGet(method, query); data != nil {
+		switch v := data.(type) {
+		case T:
+			return &v, err
+		case *T:
+			return v, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	fetch := func() (any, error) {
+		v, hdr, err := send(ctx, lfm, &request{method: method, query: query}, result)
+		// Set before the flight ends, so that later calls find it
+		var lfmErr *LastFMError
+		switch {
+		case errors.As(err, &lfmErr):
+			lfm.cacheSet(method, query, lfmErr, hdr)
+		case err == nil:
+			lfm.cacheSet(method, query, v, hdr)
+		}
+		return v, err
+	}
+	if lfm.flights == nil {
+		v, err := fetch()
+		return v.(*T), err
+	}
+	v, err := lfm.flights.do(ctx, makeCacheKey(method, query), fetch)
+	if v == nil {
+		return nil, err
+	}
+	return v.(*T), err
+}
+
+// Like call, but never cached, for signed calls and calls that change things.
+// Also returns the response's headers.
+//
+// The request waits for the rate limiter, and is retried as the retry policy
+// allows. If *T implements unmarshalHelper, it's run on the result.
+func send[T any](ctx context.Context, lfm *LastFM, r *request, result func(*lfmStatus) *T) (*T, http.Header, error) {
+	status, hdr, err := lfm.executeWithRetries(ctx, r)
+	if err != nil {
+		return nil, hdr, err
+	}
+
+	v := result(status)
+	if helper, ok := any(v).(unmarshalHelper); ok {
+		if err = helper.unmarshalHelper(); err != nil {
+			return v, hdr, err
+		}
+	}
+	return v, hdr, nil
+}
+
+// Queries the API servers and decodes the response.
+func (lfm *LastFM) execute(ctx context.Context, r *request) (status *lfmStatus, hdr http.Header, err error) {
+	method := r.method
+	resp, err := lfm.doQuery(ctx, r)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	hdr = resp.Header
+
+	body := &readErrorRecorder{r: resp.Body}
+	status = &lfmStatus{}
+	err = lfm.decode(body, status)
+	switch {
+	case status.Error.Code != 0:
+		// sent along with 4xx statuses, too
+		return nil, hdr, &status.Error
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, hdr, &HTTPError{Method: method, StatusCode: resp.StatusCode, Status: resp.Status}
+	case body.err != nil:
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, hdr, ctxErr
+		}
+		return nil, hdr, &RequestError{Method: method, Err: body.err}
+	case err != nil:
+		return nil, hdr, &DecodeError{Method: method, Err: err}
+	}
+	return status, hdr, nil
+}
+
+// Remembers the error of a failed read, to tell it apart from decoding errors.
+type readErrorRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrorRecorder) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return
+}
@@ -0,0 +1,138 @@
+package lastfm
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Answers every request with the same response, or fails with err.
+type fakeGetter struct {
+	status string
+	body   string
+	err    error
+
+	req *http.Request // The last request
+}
+
+func (g *fakeGetter) Do(req *http.Request) (*http.Response, error) {
+	g.req = req
+	if g.err != nil {
+		return nil, &url.Error{Op: "Get", URL: req.URL.String(), Err: g.err}
+	}
+	code := 0
+	for _, c := range g.status[:3] {
+		code = code*10 + int(c-'0')
+	}
+	return &http.Response{StatusCode: code, Status: g.status, Body: io.NopCloser(strings.NewReader(g.body))}, nil
+}
+
+func fakeLastFM(g *fakeGetter) LastFM {
+	lfm := New("secret-api-key")
+	lfm.getter = g
+	lfm.Retry.MinBackoff = time.Millisecond
+	return lfm
+}
+
+func TestCall_HTTPError(T *testing.T) {
+	lfm := fakeLastFM(&fakeGetter{status: "503 Service Unavailable", body: "<html>Down for maintenance</html>"})
+	_, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1)
+
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 503 {
+		T.Errorf("Expected an HTTP 503 error -- Got %#v", err)
+	}
+}
+
+func TestCall_LastFMErrorWithHTTPStatus(T *testing.T) {
+	lfm := fakeLastFM(&fakeGetter{
+		status: "400 Bad Request",
+		body:   `<lfm status="failed"><error code="6">User not found</error></lfm>`})
+	_, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1)
+
+	var lfmErr *LastFMError
+	if !errors.As(err, &lfmErr) || lfmErr.Code != 6 {
+		T.Errorf("Expected Last.fm error 6 -- Got %#v", err)
+	}
+}
+
+func TestCall_DecodeError(T *testing.T) {
+	lfm := fakeLastFM(&fakeGetter{status: "200 OK", body: "<lfm status="})
+	_, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1)
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		T.Errorf("Expected a decoding error -- Got %#v", err)
+	}
+}
+
+func TestCall_RequestError(T *testing.T) {
+	lfm := fakeLastFM(&fakeGetter{err: errors.New("connection refused")})
+	_, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1)
+
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		T.Errorf("Expected a request error -- Got %#v", err)
+	} else if strings.Contains(err.Error(), "secret-api-key") {
+		T.Errorf("Expected the error not to include the API key -- Got %q", err)
+	}
+}
+
+func TestCall_CachedLastFMError(T *testing.T) {
+	lfm := fakeLastFM(&fakeGetter{err: errors.New("no requests expected")})
+	query := map[string]string{"user": "Kovensky"}
+	// LoadCache gives back values, not pointers
+	lfm.Cache.Set(makeCacheKey("user.getInfo", query), LastFMError{Code: 6, Message: "User not found"}, 0)
+	_, err := lfm.GetUserInfo(context.Background(), "Kovensky")
+
+	var lfmErr *LastFMError
+	if !errors.As(err, &lfmErr) || lfmErr.Code != 6 || err.Error() != "User not found" {
+		T.Errorf("Expected cached Last.fm error 6 -- Got %#v", err)
+	}
+}
+
+func TestSignedRequest(T *testing.T) {
+	g := &fakeGetter{status: "200 OK", body: `{"token":"t"}`}
+	lfm := NewWithSecret("key", "secret")
+	lfm.getter = g
+	lfm.Format = JSON // format isn't signed
+	if _, err := lfm.GetToken(context.Background()); err != nil {
+		T.Fatal(err)
+	}
+
+	sum := md5.Sum([]byte("api_keykeymethodauth.getTokensecret"))
+	if sig := g.req.URL.Query().Get("api_sig"); sig != hex.EncodeToString(sum[:]) {
+		T.Errorf("Expected api_sig %x -- Got %q", sum, sig)
+	}
+}
+
+func TestPostRequest(T *testing.T) {
+	g := &fakeGetter{status: "200 OK", body: `<lfm status="ok"><session><name>u</name><key>k</key></session></lfm>`}
+	lfm := NewWithSecret("key", "secret")
+	lfm.getter = g
+	session, err := lfm.GetMobileSession(context.Background(), "u", "p&ss")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if session.Key != "k" {
+		T.Errorf("Expected session key %q -- Got %q", "k", session.Key)
+	}
+
+	if g.req.Method != "POST" || g.req.URL.RawQuery != "" {
+		T.Fatalf("Expected a POST without a query string -- Got %s %s", g.req.Method, g.req.URL)
+	}
+	if err := g.req.ParseForm(); err != nil {
+		T.Fatal(err)
+	}
+	sum := md5.Sum([]byte("api_keykeymethodauth.getMobileSessionpasswordp&ssusernameusecret"))
+	if sig := g.req.PostForm.Get("api_sig"); sig != hex.EncodeToString(sum[:]) {
+		T.Errorf("Expected api_sig %x -- Got %q", sum, sig)
+	}
+}
@@ -0,0 +1,175 @@
+package lastfm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The most scrobbles that can be sent in one call to Scrobble.
+const MaxScrobbles = 50
+
+// A track listened to by the user. Only Artist, Track and, when scrobbling,
+// Timestamp are required.
+type Scrobble struct {
+	Artist      string
+	Track       string
+	Timestamp   time.Time // When the track started playing
+	Album       string
+	AlbumArtist string // If different from Artist
+	TrackNumber int
+	MBID        string // Of the track
+	Duration    time.Duration
+}
+
+// Adds the scrobble's parameters to query; if i is not negative, they're
+// given as the ith scrobble of a batch.
+func (s *Scrobble) addParams(query map[string]string, i int) {
+	set := func(key, value string) {
+		if i >= 0 {
+			key = fmt.Sprintf("%s[%d]", key, i)
+		}
+		if value != "" {
+			query[key] = value
+		}
+	}
+	set("artist", s.Artist)
+	set("track", s.Track)
+	if !s.Timestamp.IsZero() {
+		set("timestamp", strconv.FormatInt(s.Timestamp.Unix(), 10))
+	}
+	set("album", s.Album)
+	set("albumArtist", s.AlbumArtist)
+	if s.TrackNumber > 0 {
+		set("trackNumber", strconv.Itoa(s.TrackNumber))
+	}
+	set("mbid", s.MBID)
+	if s.Duration > 0 {
+		set("duration", strconv.Itoa(int(s.Duration/time.Second)))
+	}
+}
+
+// Why Last.fm ignored a scrobble or now playing update.
+type IgnoredReason int
+
+const (
+	NotIgnored IgnoredReason = iota
+	ArtistIgnored
+	TrackIgnored
+	TimestampTooOld
+	TimestampTooNew
+	DailyLimitExceeded
+)
+
+var ignoredReasonStringMap = map[IgnoredReason]string{
+	NotIgnored:         "not ignored",
+	ArtistIgnored:      "artist ignored",
+	TrackIgnored:       "track ignored",
+	TimestampTooOld:    "timestamp too old",
+	TimestampTooNew:    "timestamp too new",
+	DailyLimitExceeded: "daily scrobble limit exceeded",
+}
+
+func (r IgnoredReason) String() string {
+	if s, ok := ignoredReasonStringMap[r]; ok {
+		return s
+	}
+	return "ignored (code " + strconv.Itoa(int(r)) + ")"
+}
+
+// A name as Last.fm understood it, and whether it was corrected from the one
+// that was sent.
+type Corrected struct {
+	Name      string `xml:",chardata"`
+	Corrected bool   `xml:"corrected,attr"`
+}
+
+// What Last.fm did with a scrobble or now playing update.
+type ScrobbleResult struct {
+	Artist      Corrected `xml:"artist"`
+	Track       Corrected `xml:"track"`
+	Album       Corrected `xml:"album"`
+	AlbumArtist Corrected `xml:"albumArtist"`
+	Timestamp   time.Time `xml:"-"` // Zero for now playing updates
+
+	IgnoredReason  IgnoredReason `xml:"-"`
+	IgnoredMessage string        `xml:"-"`
+
+	// For internal use
+	RawTimestamp int64 `xml:"timestamp"`
+	RawIgnored   struct {
+		Code    IgnoredReason `xml:"code,attr"`
+		Message string        `xml:",chardata"`
+	} `xml:"ignoredMessage"`
+}
+
+func (r *ScrobbleResult) unmarshalHelper() (err error) {
+	if r.RawTimestamp != 0 {
+		r.Timestamp = time.Unix(r.RawTimestamp, 0)
+	}
+	r.IgnoredReason = r.RawIgnored.Code
+	r.IgnoredMessage = r.RawIgnored.Message
+	return
+}
+
+// The results of a call to Scrobble, in the same order as the scrobbles.
+type ScrobbleBatch struct {
+	Accepted int              `xml:"accepted,attr"`
+	Ignored  int              `xml:"ignored,attr"`
+	Results  []ScrobbleResult `xml:"scrobble"`
+}
+
+func (b *ScrobbleBatch) unmarshalHelper() (err error) {
+	for i := range b.Results {
+		if err = b.Results[i].unmarshalHelper(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Scrobbles up to MaxScrobbles tracks the user of the session listened to.
+// Scrobbles that Last.fm ignores are not an error; see the returned batch's
+// Ignored count and each result's IgnoredReason.
+//
+// See http://www.last.fm/api/show/track.scrobble.
+func (lfm *LastFM) Scrobble(ctx context.Context, session *Session, scrobbles ...Scrobble) (batch *ScrobbleBatch, err error) {
+	if len(scrobbles) == 0 || len(scrobbles) > MaxScrobbles {
+		return nil, fmt.Errorf("lastfm: can only scrobble 1 to %d tracks at once, not %d", MaxScrobbles, len(scrobbles))
+	}
+	query := map[string]string{}
+	for i := range scrobbles {
+		if scrobbles[i].Timestamp.IsZero() {
+			return nil, errors.New("lastfm: scrobbles need a timestamp")
+		}
+		scrobbles[i].addParams(query, i)
+	}
+
+	batch, _, err = send(ctx, lfm, &request{
+		method:  "track.scrobble",
+		query:   query,
+		post:    true,
+		session: session,
+	}, func(s *lfmStatus) *ScrobbleBatch { return &s.Scrobbles })
+	return
+}
+
+// Tells Last.fm the user of the session started listening to a track. The
+// track's Timestamp is not used.
+//
+// See http://www.last.fm/api/show/track.updateNowPlaying.
+func (lfm *LastFM) UpdateNowPlaying(ctx context.Context, session *Session, track Scrobble) (result *ScrobbleResult, err error) {
+	track.Timestamp = time.Time{}
+	query := map[string]string{}
+	track.addParams(query, -1)
+
+	result, _, err = send(ctx, lfm, &request{
+		method:  "track.updateNowPlaying",
+		query:   query,
+		post:    true,
+		session: session,
+	}, func(s *lfmStatus) *ScrobbleResult { return &s.NowPlaying })
+	return
+}
@@ -0,0 +1,181 @@
+package lastfm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A scrobble waiting in a ScrobbleQueue, with the session to send it as.
+type queuedScrobble struct {
+	Session  Session
+	Scrobble Scrobble
+}
+
+// Keeps scrobbles in a file until Last.fm answers them, so that none are lost
+// while it can't be reached, or when the program is restarted. The file has
+// the users' session keys, so it's only readable by its owner.
+//
+// It is safe for concurrent use, but not by several processes.
+type ScrobbleQueue struct {
+	lfm  *LastFM
+	path string
+
+	flushing sync.Mutex // Held by Flush
+	mutex    sync.Mutex // Protects pending and the file
+	pending  []queuedScrobble
+}
+
+// Opens the queue kept in the file at path, which is created when scrobbles
+// are first added. Scrobbles are sent with lfm, which needs a secret.
+func NewScrobbleQueue(lfm *LastFM, path string) (*ScrobbleQueue, error) {
+	q := &ScrobbleQueue{lfm: lfm, path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &q.pending); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// Adds scrobbles by the user of session to the queue, and saves it. They're
+// sent on the next Flush. If the queue can't be saved, they're not added, so
+// Add can be called again with them.
+func (q *ScrobbleQueue) Add(session *Session, scrobbles ...Scrobble) error {
+	for _, s := range scrobbles {
+		if s.Timestamp.IsZero() {
+			return errors.New("lastfm: scrobbles need a timestamp")
+		}
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	n := len(q.pending)
+	for _, s := range scrobbles {
+		q.pending = append(q.pending, queuedScrobble{Session: *session, Scrobble: s})
+	}
+	if err := q.save(); err != nil {
+		q.pending = q.pending[:n]
+		return err
+	}
+	return nil
+}
+
+// Returns how many scrobbles are waiting to be sent.
+func (q *ScrobbleQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.pending)
+}
+
+// Sends the queued scrobbles, in batches of up to MaxScrobbles by the same
+// user, and removes the ones Last.fm answered, whether it accepted them or
+// not. Returns how many were accepted.
+//
+// When a batch fails because Last.fm is unavailable, it and the ones after it
+// are kept for the next Flush, which should be done a while later. Batches
+// that fail for other reasons, such as a revoked session, are dropped, as
+// sending them again would fail the same way. In both cases, the first error
+// is returned.
+func (q *ScrobbleQueue) Flush(ctx context.Context) (accepted int, err error) {
+	q.flushing.Lock()
+	defer q.flushing.Unlock()
+
+	for {
+		// Only Flush removes scrobbles, so the batch stays at the front
+		q.mutex.Lock()
+		batch := q.nextBatch()
+		q.mutex.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+
+		scrobbles := make([]Scrobble, len(batch))
+		for i := range batch {
+			scrobbles[i] = batch[i].Scrobble
+		}
+		result, sendErr := q.lfm.Scrobble(ctx, &batch[0].Session, scrobbles...)
+		if sendErr != nil {
+			if err == nil {
+				err = sendErr
+			}
+			if IsTemporary(sendErr) || ctx.Err() != nil {
+				return
+			}
+		} else {
+			accepted += result.Accepted
+		}
+
+		q.mutex.Lock()
+		q.pending = q.pending[len(batch):]
+		saveErr := q.save()
+		q.mutex.Unlock()
+		if saveErr != nil {
+			if err == nil {
+				err = saveErr
+			}
+			return
+		}
+	}
+}
+
+// Flushes the queue every interval, until ctx is done. Errors are given to
+// onError, if it's not nil.
+func (q *ScrobbleQueue) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := q.Flush(ctx); err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Returns the scrobbles at the front of the queue that can be sent together.
+// Must be called with the mutex held.
+func (q *ScrobbleQueue) nextBatch() []queuedScrobble {
+	n := 0
+	for n < len(q.pending) && n < MaxScrobbles && q.pending[n].Session.Key == q.pending[0].Session.Key {
+		n++
+	}
+	return append([]queuedScrobble(nil), q.pending[:n]...)
+}
+
+// Writes the queue to a temporary file, then replaces the old one with it,
+// so that a crash leaves either of them intact. Must be called with the mutex
+// held.
+func (q *ScrobbleQueue) save() error {
+	data, err := json.Marshal(q.pending)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly after the rename
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), q.path)
+}
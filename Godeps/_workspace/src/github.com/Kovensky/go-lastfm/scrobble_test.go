@@ -0,0 +1,213 @@
+package lastfm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stands in for the Last.fm servers, for the scrobbling methods.
+type fakeScrobbler struct {
+	sync.Mutex
+	T           *testing.T
+	unavailable bool       // Answer with error 16
+	scrobbles   url.Values // Every scrobble parameter received
+	requests    int
+}
+
+func (f *fakeScrobbler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.requests++
+	if req.Method != "POST" {
+		f.T.Errorf("Expected a POST -- Got %s", req.Method)
+	}
+	req.ParseForm()
+	if sig := sign(req.PostForm, "secret"); req.PostForm.Get("api_sig") != sig {
+		f.T.Errorf("Expected api_sig %s -- Got %q", sig, req.PostForm.Get("api_sig"))
+	}
+	if req.PostForm.Get("sk") != "session-key" {
+		fmt.Fprint(w, `<lfm status="failed"><error code="9">Invalid session key - Please re-authenticate</error></lfm>`)
+		return
+	}
+	if f.unavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `<lfm status="failed"><error code="16">There was a temporary error processing your request. Please try again</error></lfm>`)
+		return
+	}
+
+	switch req.PostForm.Get("method") {
+	case "track.updateNowPlaying":
+		fmt.Fprintf(w, `<lfm status="ok"><nowplaying><track corrected="0">%s</track><artist corrected="1">%s</artist>
+			<album corrected="0"></album><albumArtist corrected="0"></albumArtist><ignoredMessage code="0"></ignoredMessage>
+			</nowplaying></lfm>`, req.PostForm.Get("track"), req.PostForm.Get("artist"))
+	case "track.scrobble":
+		var results []string
+		accepted, ignored := 0, 0
+		for i := 0; req.PostForm.Get(fmt.Sprintf("artist[%d]", i)) != ""; i++ {
+			for key, values := range req.PostForm {
+				if strings.HasSuffix(key, fmt.Sprintf("[%d]", i)) {
+					f.scrobbles[key] = values
+				}
+			}
+			track := req.PostForm.Get(fmt.Sprintf("track[%d]", i))
+			code := 0
+			if track == "Ignored" {
+				code = 2
+				ignored++
+			} else {
+				accepted++
+			}
+			results = append(results, fmt.Sprintf(`<scrobble><track corrected="0">%s</track><artist corrected="0">%s</artist>
+				<album corrected="0"></album><albumArtist corrected="0"></albumArtist><timestamp>%s</timestamp>
+				<ignoredMessage code="%d"></ignoredMessage></scrobble>`,
+				track, req.PostForm.Get(fmt.Sprintf("artist[%d]", i)), req.PostForm.Get(fmt.Sprintf("timestamp[%d]", i)), code))
+		}
+		fmt.Fprintf(w, `<lfm status="ok"><scrobbles accepted="%d" ignored="%d">%s</scrobbles></lfm>`,
+			accepted, ignored, strings.Join(results, ""))
+	default:
+		f.T.Errorf("Unexpected method %q", req.PostForm.Get("method"))
+	}
+}
+
+// Sends every request to the test server instead of Last.fm.
+type testServerGetter struct {
+	server *httptest.Server
+}
+
+func (g *testServerGetter) Do(req *http.Request) (*http.Response, error) {
+	u, _ := url.Parse(g.server.URL)
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host, req.Host = u.Scheme, u.Host, ""
+	return g.server.Client().Do(req)
+}
+
+func newFakeScrobbler(T *testing.T) (*fakeScrobbler, *LastFM) {
+	f := &fakeScrobbler{T: T, scrobbles: url.Values{}}
+	server := httptest.NewServer(f)
+	T.Cleanup(server.Close)
+	lfm := NewWithSecret("key", "secret")
+	lfm.getter = &testServerGetter{server}
+	lfm.Retry.MinBackoff = time.Millisecond
+	return f, &lfm
+}
+
+var testSession = &Session{User: "Kovensky", Key: "session-key"}
+
+func TestScrobble(T *testing.T) {
+	f, lfm := newFakeScrobbler(T)
+	start := time.Unix(1368900185, 0)
+	batch, err := lfm.Scrobble(context.Background(), testSession,
+		Scrobble{Artist: "Daft Punk", Track: "Motherboard", Timestamp: start, Album: "Random Access Memories",
+			TrackNumber: 10, Duration: 326 * time.Second},
+		Scrobble{Artist: "Daft Punk", Track: "Ignored", Timestamp: start.Add(326 * time.Second)})
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	if batch.Accepted != 1 || batch.Ignored != 1 || len(batch.Results) != 2 {
+		T.Fatalf("Expected 1 accepted and 1 ignored scrobble -- Got %+v", batch)
+	}
+	if r := batch.Results[0]; r.Track.Name != "Motherboard" || r.IgnoredReason != NotIgnored || !r.Timestamp.Equal(start) {
+		T.Errorf("Expected Motherboard to be accepted -- Got %+v", r)
+	}
+	if r := batch.Results[1]; r.IgnoredReason != TrackIgnored {
+		T.Errorf("Expected the second track to be ignored -- Got %v", r.IgnoredReason)
+	}
+	for key, value := range map[string]string{
+		"album[0]": "Random Access Memories", "trackNumber[0]": "10", "duration[0]": "326",
+		"timestamp[1]": "1368900511"} {
+		if got := f.scrobbles.Get(key); got != value {
+			T.Errorf("Expected %s %q -- Got %q", key, value, got)
+		}
+	}
+}
+
+func TestScrobble_TooMany(T *testing.T) {
+	_, lfm := newFakeScrobbler(T)
+	scrobbles := make([]Scrobble, MaxScrobbles+1)
+	if _, err := lfm.Scrobble(context.Background(), testSession, scrobbles...); err == nil {
+		T.Error("Expected an error")
+	}
+}
+
+func TestUpdateNowPlaying(T *testing.T) {
+	_, lfm := newFakeScrobbler(T)
+	result, err := lfm.UpdateNowPlaying(context.Background(), testSession,
+		Scrobble{Artist: "Daft Punk", Track: "Aerodynamic"})
+	if err != nil {
+		T.Fatal(err)
+	}
+	if result.Track.Name != "Aerodynamic" || !result.Artist.Corrected {
+		T.Errorf("Expected Aerodynamic with a corrected artist -- Got %+v", result)
+	}
+}
+
+func TestScrobbleQueue(T *testing.T) {
+	f, lfm := newFakeScrobbler(T)
+	path := filepath.Join(T.TempDir(), "scrobbles.json")
+	q, err := NewScrobbleQueue(lfm, path)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	start := time.Unix(1368900185, 0)
+	for i := 0; i < MaxScrobbles+10; i++ {
+		err = q.Add(testSession, Scrobble{Artist: "Daft Punk", Track: "Motherboard", Timestamp: start.Add(time.Duration(i) * time.Minute)})
+		if err != nil {
+			T.Fatal(err)
+		}
+	}
+	q.Add(&Session{User: "someone", Key: "revoked"}, Scrobble{Artist: "Daft Punk", Track: "Motherboard", Timestamp: start})
+
+	f.unavailable = true
+	if accepted, err := q.Flush(context.Background()); accepted != 0 || !IsTemporary(err) {
+		T.Errorf("Expected a temporary error -- Got %d accepted, error %v", accepted, err)
+	}
+
+	// as if the program was restarted
+	q, err = NewScrobbleQueue(lfm, path)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if q.Len() != MaxScrobbles+11 {
+		T.Fatalf("Expected %d queued scrobbles -- Got %d", MaxScrobbles+11, q.Len())
+	}
+
+	f.unavailable = false
+	f.requests = 0
+	accepted, err := q.Flush(context.Background())
+	if lfmErr, ok := err.(*LastFMError); !ok || lfmErr.Code != 9 {
+		T.Errorf("Expected error code 9 for the revoked session -- Got %v", err)
+	}
+	if accepted != MaxScrobbles+10 || f.requests != 3 {
+		T.Errorf("Expected %d scrobbles accepted in 3 requests -- Got %d in %d", MaxScrobbles+10, accepted, f.requests)
+	}
+	if q.Len() != 0 {
+		T.Errorf("Expected the revoked session's scrobble to be dropped -- Got %d queued", q.Len())
+	}
+	if f.scrobbles.Get("timestamp[9]") != "1368903725" {
+		T.Errorf("Expected the last scrobble to be sent -- Got timestamp %q", f.scrobbles.Get("timestamp[9]"))
+	}
+}
+
+func TestScrobbleQueue_AddSaveError(T *testing.T) {
+	_, lfm := newFakeScrobbler(T)
+	q, err := NewScrobbleQueue(lfm, filepath.Join(T.TempDir(), "missing", "scrobbles.json"))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err = q.Add(testSession, Scrobble{Artist: "Daft Punk", Track: "Motherboard", Timestamp: time.Unix(1368900185, 0)}); err == nil {
+		T.Fatal("Expected an error saving to a missing directory")
+	}
+	if q.Len() != 0 {
+		T.Errorf("Expected the scrobble not to be queued -- Got %d queued", q.Len())
+	}
+}
@@ -0,0 +1,90 @@
+package lastfm
+
+import (
+	"context"
+	"strconv"
+)
+
+// A track found by SearchTracks.
+type TrackMatch struct {
+	Name      string `xml:"name"`
+	Artist    string `xml:"artist"`
+	MBID      string `xml:"mbid"`
+	URL       string `xml:"url"`
+	Listeners int    `xml:"listeners"`
+	Images    Images `xml:"image"`
+}
+
+// The results of SearchArtists, SearchAlbums or SearchTracks. Only the list of
+// what was searched for is set.
+type SearchResults struct {
+	Query      string `xml:"for,attr"`
+	Total      int    `xml:"totalResults"`
+	Page       int    `xml:"-"`
+	PerPage    int    `xml:"itemsPerPage"`
+	TotalPages int    `xml:"-"`
+
+	Artists []Artist     `xml:"artistmatches>artist"`
+	Albums  []AlbumInfo  `xml:"albummatches>album"`
+	Tracks  []TrackMatch `xml:"trackmatches>track"`
+
+	// For internal use
+	RawStartIndex int `xml:"startIndex"`
+}
+
+func (results *SearchResults) unmarshalHelper() (err error) {
+	if results.PerPage > 0 {
+		results.Page = results.RawStartIndex/results.PerPage + 1
+		results.TotalPages = (results.Total + results.PerPage - 1) / results.PerPage
+	}
+	for i := range results.Albums {
+		if err = results.Albums[i].unmarshalHelper(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Gets a page of up to limit artists whose names match the given one, the best
+// match first. Pages start at 1. Searches can't be done by MBID, nor be
+// autocorrected.
+//
+// See http://www.last.fm/api/show/artist.search.
+func (lfm *LastFM) SearchArtists(ctx context.Context, artist string, page, limit int) (results *SearchResults, err error) {
+	query := map[string]string{
+		"artist": artist,
+		"page":   strconv.Itoa(page),
+		"limit":  strconv.Itoa(limit)}
+
+	return call(ctx, lfm, "artist.search", query, func(s *lfmStatus) *SearchResults { return &s.SearchResults })
+}
+
+// Gets a page of up to limit albums whose names match the given one. Works
+// like SearchArtists otherwise.
+//
+// See http://www.last.fm/api/show/album.search.
+func (lfm *LastFM) SearchAlbums(ctx context.Context, album string, page, limit int) (results *SearchResults, err error) {
+	query := map[string]string{
+		"album": album,
+		"page":  strconv.Itoa(page),
+		"limit": strconv.Itoa(limit)}
+
+	return call(ctx, lfm, "album.search", query, func(s *lfmStatus) *SearchResults { return &s.SearchResults })
+}
+
+// Gets a page of up to limit tracks whose names match the given one and, if
+// artist isn't empty (""), whose artist matches it. Works like SearchArtists
+// otherwise.
+//
+// See http://www.last.fm/api/show/track.search.
+func (lfm *LastFM) SearchTracks(ctx context.Context, track, artist string, page, limit int) (results *SearchResults, err error) {
+	query := map[string]string{
+		"track": track,
+		"page":  strconv.Itoa(page),
+		"limit": strconv.Itoa(limit)}
+	if artist != "" {
+		query["artist"] = artist
+	}
+
+	return call(ctx, lfm, "track.search", query, func(s *lfmStatus) *SearchResults { return &s.SearchResults })
+}
@@ -0,0 +1,49 @@
+package lastfm_test
+
+import (
+	"context"
+	"github.com/Kovensky/go-lastfm"
+	"testing"
+)
+
+func TestSearchArtists(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	results, err := lfm.SearchArtists(context.Background(), "Daft Punk", 1, 2)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "query", "Daft Punk", results.Query)
+		Expect(T, "result count", 151, results.Total)
+		Expect(T, "page", 1, results.Page)
+		Expect(T, "page count", 76, results.TotalPages)
+		if Expect(T, "artist count", 2, len(results.Artists)) {
+			Expect(T, "best match", "Daft Punk", results.Artists[0].Name)
+			Expect(T, "best match's listeners", 2495427, results.Artists[0].Listeners)
+		}
+	}
+}
+
+func TestSearchAlbums(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	results, err := lfm.SearchAlbums(context.Background(), "Discovery", 1, 2)
+
+	if Expect(T, "error", nil, err) && Expect(T, "album count", 2, len(results.Albums)) {
+		Expect(T, "best match", "Discovery", results.Albums[0].Name)
+		Expect(T, "best match's artist", "Daft Punk", results.Albums[0].Artist)
+		Expect(T, "second match's artist", "Electric Light Orchestra", results.Albums[1].Artist)
+		Expect(T, "artist count", 0, len(results.Artists))
+	}
+}
+
+func TestSearchTracks(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	results, err := lfm.SearchTracks(context.Background(), "Motherboard", "Daft Punk", 1, 2)
+
+	if Expect(T, "error", nil, err) && Expect(T, "track count", 2, len(results.Tracks)) {
+		Expect(T, "page count", 2, results.TotalPages)
+		Expect(T, "best match's artist", "Daft Punk", results.Tracks[0].Artist)
+		Expect(T, "best match's listeners", 98231, results.Tracks[0].Listeners)
+	}
+}
@@ -1,6 +1,8 @@
 package lastfm
 
-import "encoding/xml"
+import (
+	"context"
+)
 
 type Tag struct {
 	Name  string `xml:"name"`
@@ -11,120 +13,34 @@ type Tag struct {
 type TopTags struct {
 	Artist string `xml:"artist,attr"`
 	Track  string `xml:"track,attr"`
+	User   string `xml:"user,attr"`
 	Tags   []Tag  `xml:"tag"`
 }
 
-// Gets the top tags for a Track. The second argument tells last.fm whether
+// Gets the top tags for a Track. The autocorrect argument tells last.fm whether
 // it is to apply autocorrections to the name/artist.
 //
 // The Track struct must specify either the MBID or both Artist.Name and Name.
-// Example literals that can be given as the first argument:
+// Example literals that can be given as the track argument:
 //   lastfm.Track{MBID: "mbid"}
 //   lastfm.Track{Artist: lastfm.Artist{Name: "Artist"}, Name: "Track"}
 //
 // See http://www.last.fm/api/show/track.getTopTags.
-func (lfm *LastFM) GetTrackTopTags(track Track, autocorrect bool) (toptags *TopTags, err error) {
-	method := "track.getTopTags"
-	query := map[string]string{}
-	if autocorrect {
-		query["autocorrect"] = "1"
-	} else {
-		query["autocorrect"] = "0"
-	}
-
-	if track.MBID != "" {
-		query["mbid"] = track.MBID
-	} else {
-		query["artist"] = track.Artist.Name
-		query["track"] = track.Name
-	}
-
-	if data, err := lfm.cacheGet(method, query); data != nil {
-		switch v := data.(type) {
-		case TopTags:
-			return &v, err
-		case *TopTags:
-			return v, err
-		}
-	} else if err != nil {
-		return nil, err
-	}
-
-	body, hdr, err := lfm.doQuery(method, query)
-	if err != nil {
-		return
-	}
-	defer body.Close()
-
-	status := lfmStatus{}
-	err = xml.NewDecoder(body).Decode(&status)
-	if err != nil {
-		return
-	}
-	if status.Error.Code != 0 {
-		err = &status.Error
-		go lfm.cacheSet(method, query, err, hdr)
-		return
-	}
-
-	toptags = &status.TopTags
-	go lfm.cacheSet(method, query, toptags, hdr)
-	return
+func (lfm *LastFM) GetTrackTopTags(ctx context.Context, track Track, autocorrect bool) (toptags *TopTags, err error) {
+	return call(ctx, lfm, "track.getTopTags", trackQuery(track, autocorrect),
+		func(s *lfmStatus) *TopTags { return &s.TopTags })
 }
 
-// Gets the top tags for an Artist. The second argument tells last.fm whether
+// Gets the top tags for an Artist. The autocorrect argument tells last.fm whether
 // it is to apply autocorrections to the artist name.
 //
 // The Artist struct must specify either the MBID or the Name.
-// Example literals that can be given as the first argument:
+// Example literals that can be given as the artist argument:
 //   lastfm.Artist{MBID: "mbid"}
 //   lastfm.Artist{Name: "Artist"}
 //
 // See http://www.last.fm/api/show/artist.getTopTags.
-func (lfm *LastFM) GetArtistTopTags(artist Artist, autocorrect bool) (toptags *TopTags, err error) {
-	method := "artist.getTopTags"
-	query := map[string]string{}
-	if autocorrect {
-		query["autocorrect"] = "1"
-	} else {
-		query["autocorrect"] = "0"
-	}
-
-	if artist.MBID != "" {
-		query["mbid"] = artist.MBID
-	} else {
-		query["artist"] = artist.Name
-	}
-
-	if data, err := lfm.cacheGet(method, query); data != nil {
-		switch v := data.(type) {
-		case TopTags:
-			return &v, err
-		case *TopTags:
-			return v, err
-		}
-	} else if err != nil {
-		return nil, err
-	}
-
-	body, hdr, err := lfm.doQuery(method, query)
-	if err != nil {
-		return
-	}
-	defer body.Close()
-
-	status := lfmStatus{}
-	err = xml.NewDecoder(body).Decode(&status)
-	if err != nil {
-		return
-	}
-	if status.Error.Code != 0 {
-		err = &status.Error
-		go lfm.cacheSet(method, query, err, hdr)
-		return
-	}
-
-	toptags = &status.TopTags
-	go lfm.cacheSet(method, query, toptags, hdr)
-	return
+func (lfm *LastFM) GetArtistTopTags(ctx context.Context, artist Artist, autocorrect bool) (toptags *TopTags, err error) {
+	return call(ctx, lfm, "artist.getTopTags", artistQuery(artist, autocorrect),
+		func(s *lfmStatus) *TopTags { return &s.TopTags })
 }
@@ -1,6 +1,7 @@
 package lastfm_test
 
 import (
+	"context"
 	"github.com/Kovensky/go-lastfm"
 	"testing"
 )
@@ -9,7 +10,7 @@ func TestGetTrackTopTags(T *testing.T) {
 	T.Parallel()
 	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
 	topTags, err := lfm.GetTrackTopTags(
-		lastfm.Track{MBID: "48fa1cab-5250-4767-bbdf-14e0ef563d11"}, false)
+		context.Background(), lastfm.Track{MBID: "48fa1cab-5250-4767-bbdf-14e0ef563d11"}, false)
 
 	if Expect(T, "error", nil, err) {
 		Expect(T, "track name", "One More Time", topTags.Track)
@@ -22,7 +23,7 @@ func TestGetArtistTopTags(T *testing.T) {
 	T.Parallel()
 	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
 	topTags, err := lfm.GetArtistTopTags(
-		lastfm.Artist{Name: "Daft Punk"}, false)
+		context.Background(), lastfm.Artist{Name: "Daft Punk"}, false)
 
 	if Expect(T, "error", nil, err) {
 		Expect(T, "track name", "", topTags.Track)
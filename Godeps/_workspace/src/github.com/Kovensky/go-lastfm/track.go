@@ -1,7 +1,10 @@
 package lastfm
 
 import (
-	"encoding/xml"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -44,13 +47,27 @@ func (info *TrackInfo) unmarshalHelper() (err error) {
 // last.fm's autocorrection algorithms should be run on the artist or track names.
 //
 // The Track struct must specify either the MBID or both Artist.Name and Name.
-// Example literals that can be given as the first argument:
+// Example literals that can be given as the track argument:
 //   lastfm.Track{MBID: "mbid"}
 //   lastfm.Track{Artist: lastfm.Artist{Name: "Artist"}, Name: "Track"}
 //
 // See http://www.last.fm/api/show/track.getInfo.
-func (lfm *LastFM) GetTrackInfo(track Track, user string, autocorrect bool) (info *TrackInfo, err error) {
-	method := "track.getInfo"
+func (lfm *LastFM) GetTrackInfo(ctx context.Context, track Track, user string, autocorrect bool) (info *TrackInfo, err error) {
+	return call(ctx, lfm, "track.getInfo", trackInfoQuery(track, user, autocorrect),
+		func(s *lfmStatus) *TrackInfo { return &s.TrackInfo })
+}
+
+func trackInfoQuery(track Track, user string, autocorrect bool) map[string]string {
+	query := trackQuery(track, autocorrect)
+	if user != "" {
+		query["username"] = user
+	}
+	return query
+}
+
+// Returns the query that identifies a track, by MBID or by name, in the
+// track.* methods.
+func trackQuery(track Track, autocorrect bool) map[string]string {
 	query := map[string]string{}
 	if autocorrect {
 		query["autocorrect"] = "1"
@@ -58,49 +75,124 @@ func (lfm *LastFM) GetTrackInfo(track Track, user string, autocorrect bool) (inf
 		query["autocorrect"] = "0"
 	}
 
-	if user != "" {
-		query["username"] = user
-	}
-
 	if track.MBID != "" {
 		query["mbid"] = track.MBID
 	} else {
 		query["artist"] = track.Artist.Name
 		query["track"] = track.Name
 	}
+	return query
+}
+
+type SimilarTracks struct {
+	Artist string     `xml:"artist,attr"`
+	Track  string     `xml:"track,attr"`
+	Tracks []TopTrack `xml:"track"` // The most similar first, with .Match set
+}
 
-	if data, err := lfm.cacheGet(method, query); data != nil {
-		switch v := data.(type) {
-		case TrackInfo:
-			return &v, err
-		case *TrackInfo:
-			return v, err
+func (similar *SimilarTracks) unmarshalHelper() (err error) {
+	for i := range similar.Tracks {
+		if err = similar.Tracks[i].unmarshalHelper(); err != nil {
+			return
 		}
-	} else if err != nil {
-		return nil, err
 	}
+	return
+}
 
-	body, hdr, err := lfm.doQuery(method, query)
-	if err != nil {
-		return
+// Gets up to limit tracks similar to a Track. The track and autocorrect
+// arguments work as in GetTrackInfo.
+//
+// See http://www.last.fm/api/show/track.getSimilar.
+func (lfm *LastFM) GetSimilarTracks(ctx context.Context, track Track, limit int, autocorrect bool) (similar *SimilarTracks, err error) {
+	query := trackQuery(track, autocorrect)
+	query["limit"] = strconv.Itoa(limit)
+
+	return call(ctx, lfm, "track.getSimilar", query, func(s *lfmStatus) *SimilarTracks { return &s.SimilarTracks })
+}
+
+// Marks a Track as loved by the user of the session. The Track struct must
+// specify both Artist.Name and Name.
+//
+// The cached results of GetTrackInfo for the track and that user are
+// forgotten, so that .UserLoved is up to date.
+//
+// See http://www.last.fm/api/show/track.love.
+func (lfm *LastFM) LoveTrack(ctx context.Context, session *Session, track Track) error {
+	return lfm.setLoved(ctx, "track.love", session, track)
+}
+
+// Removes a Track from the loved tracks of the user of the session. Works like
+// LoveTrack otherwise.
+//
+// See http://www.last.fm/api/show/track.unlove.
+func (lfm *LastFM) UnloveTrack(ctx context.Context, session *Session, track Track) error {
+	return lfm.setLoved(ctx, "track.unlove", session, track)
+}
+
+func (lfm *LastFM) setLoved(ctx context.Context, method string, session *Session, track Track) error {
+	if track.Artist.Name == "" || track.Name == "" {
+		return errors.New("lastfm: " + method + " needs the artist and track names")
+	}
+	_, _, err := send(ctx, lfm, &request{
+		method:  method,
+		query:   map[string]string{"artist": track.Artist.Name, "track": track.Name},
+		post:    true,
+		session: session,
+	}, func(s *lfmStatus) *string { return &s.Status })
+	if err == nil {
+		lfm.forgetTrackInfo(track, session.User)
 	}
-	defer body.Close()
+	return err
+}
 
-	status := lfmStatus{}
-	err = xml.NewDecoder(body).Decode(&status)
-	if err != nil {
+// Removes the cached results of GetTrackInfo for the track and user, whether
+// they were looked up by MBID or by name, and however the names were written.
+// Without the track's MBID, all of the user's results looked up by MBID are
+// removed, as they can't be told apart; or, if the Cache can't list its keys,
+// the MBID is taken from the results cached by name.
+func (lfm *LastFM) forgetTrackInfo(track Track, user string) {
+	if lfm.Cache == nil {
 		return
 	}
-	if status.Error.Code != 0 {
-		err = &status.Error
-		go lfm.cacheSet(method, query, err, hdr)
-		return
+	byName := Track{Artist: Artist{Name: track.Artist.Name}, Name: track.Name}
+	mbids := []string{track.MBID}
+	lister, listed := lfm.Cache.(KeyLister)
+	if track.MBID == "" && !listed {
+		for _, autocorrect := range []bool{false, true} {
+			v, _ := lfm.Cache.Get(makeCacheKey("track.getInfo", trackInfoQuery(byName, user, autocorrect)))
+			switch info := v.(type) {
+			case *TrackInfo:
+				mbids = append(mbids, info.MBID)
+			case TrackInfo: // as loaded by LoadCache
+				mbids = append(mbids, info.MBID)
+			}
+		}
 	}
 
-	info = &status.TrackInfo
-	err = info.unmarshalHelper()
-	if err == nil {
-		go lfm.cacheSet(method, query, info, hdr)
+	tracks := []Track{byName}
+	for _, mbid := range mbids {
+		if mbid != "" {
+			tracks = append(tracks, Track{MBID: mbid})
+		}
+	}
+	for _, t := range tracks {
+		if listed {
+			query := trackInfoQuery(t, user, false)
+			delete(query, "autocorrect") // either
+			lfm.Invalidate("track.getInfo", query)
+			continue
+		}
+		// Only the exact keys can be found
+		for _, autocorrect := range []bool{false, true} {
+			lfm.cacheDelete("track.getInfo", trackInfoQuery(t, user, autocorrect))
+		}
+	}
+
+	if track.MBID == "" && listed {
+		for _, key := range lister.Keys() {
+			if strings.Contains(key, "&mbid=") && cacheKeyMatches(key, "track.getInfo", map[string]string{"username": user}) {
+				lfm.Cache.Delete(key)
+			}
+		}
 	}
-	return
 }
@@ -1,6 +1,7 @@
 package lastfm_test
 
 import (
+	"context"
 	"github.com/Kovensky/go-lastfm"
 	"testing"
 	"time"
@@ -10,7 +11,7 @@ func TestGetTrackInfo_ByMBID(T *testing.T) {
 	T.Parallel()
 	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
 	trackInfo, err := lfm.GetTrackInfo(
-		lastfm.Track{MBID: "29b45fae-fc32-43c0-ab74-052842458315"}, "", false)
+		context.Background(), lastfm.Track{MBID: "29b45fae-fc32-43c0-ab74-052842458315"}, "", false)
 
 	if Expect(T, "error", nil, err) {
 		Expect(T, "track ID", 4313, trackInfo.ID)
@@ -27,7 +28,7 @@ func TestGetTrackInfo_ByTrackArtist(T *testing.T) {
 	T.Parallel()
 	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
 	trackInfo, err := lfm.GetTrackInfo(
-		lastfm.Track{Artist: lastfm.Artist{Name: "Daft Punk"}, Name: "Motherboard"},
+		context.Background(), lastfm.Track{Artist: lastfm.Artist{Name: "Daft Punk"}, Name: "Motherboard"},
 		"Kovensky", false)
 
 	if Expect(T, "error", nil, err) {
@@ -40,3 +41,81 @@ func TestGetTrackInfo_ByTrackArtist(T *testing.T) {
 		Expect(T, "user playcount", 64, trackInfo.UserPlaycount)
 	}
 }
+
+func TestGetSimilarTracks(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	similar, err := lfm.GetSimilarTracks(
+		context.Background(), lastfm.Track{MBID: "29b45fae-fc32-43c0-ab74-052842458315"}, 2, false)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "track", "Aerodynamic", similar.Track)
+		if Expect(T, "track count", 2, len(similar.Tracks)) {
+			Expect(T, "second track", "D.A.N.C.E.", similar.Tracks[1].Name)
+			Expect(T, "second track's artist", "Justice", similar.Tracks[1].Artist.Name)
+			Expect(T, "second track's match", float32(0.763514), similar.Tracks[1].Match)
+			Expect(T, "second track's duration", 242*time.Second, similar.Tracks[1].Duration)
+		}
+	}
+}
+
+func TestLoveTrack(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.NewWithSecret("4c563adf68bc357a4570d3e7986f6481", "secret"))
+	session := &lastfm.Session{User: "Kovensky", Key: "d580d57f32848f5dcf574d1ce18d78b2"}
+	track := lastfm.Track{
+		Artist: lastfm.Artist{Name: "Daft Punk"},
+		Name:   "Motherboard",
+		MBID:   "762e120f-6d52-4fae-a796-699ae0a0ea9f"}
+
+	keys := []string{
+		"track.getInfo&artist=Daft Punk&autocorrect=1&track=Motherboard&username=Kovensky",
+		"track.getInfo&autocorrect=0&mbid=762e120f-6d52-4fae-a796-699ae0a0ea9f&username=Kovensky",
+		"track.getInfo&artist=daft punk&autocorrect=0&track=motherboard&username=kovensky",
+	}
+	for _, key := range keys {
+		lfm.Cache.Set(key, &lastfm.TrackInfo{Name: "Motherboard"}, 0)
+	}
+	// someone else's
+	lfm.Cache.Set("track.getInfo&artist=Daft Punk&autocorrect=1&track=Motherboard&username=someone", &lastfm.TrackInfo{}, 0)
+
+	if Expect(T, "error", nil, lfm.LoveTrack(context.Background(), session, track)) {
+		for _, key := range keys {
+			_, ok := lfm.Cache.Get(key)
+			Expect(T, "cached "+key, false, ok)
+		}
+		Expect(T, "cached entries", 1, lfm.Cache.Stats().Items)
+	}
+	Expect(T, "unlove error", nil, lfm.UnloveTrack(context.Background(), session, track))
+
+	lfm.Format = lastfm.JSON
+	Expect(T, "JSON error", nil, lfm.LoveTrack(context.Background(), session, track))
+}
+
+// Without an MBID, the results looked up by MBID can't be matched to the track.
+func TestLoveTrack_NoMBID(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.NewWithSecret("4c563adf68bc357a4570d3e7986f6481", "secret"))
+	session := &lastfm.Session{User: "Kovensky", Key: "d580d57f32848f5dcf574d1ce18d78b2"}
+	track := lastfm.Track{Artist: lastfm.Artist{Name: "Daft Punk"}, Name: "Motherboard"}
+
+	lfm.Cache.Set("track.getInfo&autocorrect=0&mbid=762e120f-6d52-4fae-a796-699ae0a0ea9f&username=Kovensky", &lastfm.TrackInfo{Name: "Motherboard"}, 0)
+	lfm.Cache.Set("track.getInfo&autocorrect=0&mbid=762e120f-6d52-4fae-a796-699ae0a0ea9f&username=someone", &lastfm.TrackInfo{Name: "Motherboard"}, 0)
+
+	if Expect(T, "error", nil, lfm.LoveTrack(context.Background(), session, track)) {
+		_, ok := lfm.Cache.Get("track.getInfo&autocorrect=0&mbid=762e120f-6d52-4fae-a796-699ae0a0ea9f&username=Kovensky")
+		Expect(T, "cached by MBID", false, ok)
+		Expect(T, "cached entries", 1, lfm.Cache.Stats().Items)
+	}
+}
+
+func TestLoveTrack_InvalidSession(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.NewWithSecret("4c563adf68bc357a4570d3e7986f6481", "secret"))
+	err := lfm.LoveTrack(context.Background(), &lastfm.Session{User: "Kovensky", Key: "revoked"},
+		lastfm.Track{Artist: lastfm.Artist{Name: "Daft Punk"}, Name: "Motherboard"})
+
+	if lfmErr, ok := err.(*lastfm.LastFMError); !ok || lfmErr.Code != 9 {
+		T.Errorf("Expected error code 9 -- Got %#v", err)
+	}
+}
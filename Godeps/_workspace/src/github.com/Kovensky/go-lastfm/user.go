@@ -1,13 +1,17 @@
 package lastfm
 
 import (
-	"encoding/xml"
+	"context"
 	"strconv"
+	"time"
 )
 
 type RecentTracks struct {
 	User       string  `xml:"user,attr"`
 	Total      int     `xml:"total,attr"`
+	Page       int     `xml:"page,attr"`
+	PerPage    int     `xml:"perPage,attr"`
+	TotalPages int     `xml:"totalPages,attr"`
 	Tracks     []Track `xml:"track"`
 	NowPlaying *Track  `xml:"-"` // Points to the currently playing track, if any
 }
@@ -30,47 +34,36 @@ func (tracks *RecentTracks) unmarshalHelper() (err error) {
 // The .NowPlaying field points to any currently playing track.
 //
 // See http://www.last.fm/api/show/user.getRecentTracks.
-func (lfm *LastFM) GetRecentTracks(user string, count int) (tracks *RecentTracks, err error) {
-	method := "user.getRecentTracks"
+func (lfm *LastFM) GetRecentTracks(ctx context.Context, user string, count int) (tracks *RecentTracks, err error) {
 	query := map[string]string{
 		"user":     user,
 		"extended": "1",
 		"limit":    strconv.Itoa(count)}
 
-	if data, err := lfm.cacheGet(method, query); data != nil {
-		switch v := data.(type) {
-		case RecentTracks:
-			return &v, err
-		case *RecentTracks:
-			return v, err
-		}
-	} else if err != nil {
-		return nil, err
-	}
-
-	body, hdr, err := lfm.doQuery(method, query)
-	if err != nil {
-		return
-	}
-	defer body.Close()
+	return call(ctx, lfm, "user.getRecentTracks", query, func(s *lfmStatus) *RecentTracks { return &s.RecentTracks })
+}
 
-	status := lfmStatus{}
-	err = xml.NewDecoder(body).Decode(&status)
-	if err != nil {
-		return
+// Gets a page of up to limit of the user's scrobbles between from and to,
+// either of which can be zero to leave the range open. Pages start at 1; the
+// currently playing track, if any, is included in every page.
+//
+// To get every page, use IterateRecentTracks.
+//
+// See http://www.last.fm/api/show/user.getRecentTracks.
+func (lfm *LastFM) GetRecentTracksPage(ctx context.Context, user string, from, to time.Time, page, limit int) (tracks *RecentTracks, err error) {
+	query := map[string]string{
+		"user":     user,
+		"extended": "1",
+		"page":     strconv.Itoa(page),
+		"limit":    strconv.Itoa(limit)}
+	if !from.IsZero() {
+		query["from"] = strconv.FormatInt(from.Unix(), 10)
 	}
-	if status.Error.Code != 0 {
-		err = &status.Error
-		go lfm.cacheSet(method, query, err, hdr)
-		return
+	if !to.IsZero() {
+		query["to"] = strconv.FormatInt(to.Unix(), 10)
 	}
 
-	tracks = &status.RecentTracks
-	err = tracks.unmarshalHelper()
-	if err == nil {
-		go lfm.cacheSet(method, query, tracks, hdr)
-	}
-	return
+	return call(ctx, lfm, "user.getRecentTracks", query, func(s *lfmStatus) *RecentTracks { return &s.RecentTracks })
 }
 
 type Tasteometer struct {
@@ -82,45 +75,14 @@ type Tasteometer struct {
 // Compares the taste of 2 users.
 //
 // See http://www.last.fm/api/show/tasteometer.compare.
-func (lfm *LastFM) CompareTaste(user1 string, user2 string) (taste *Tasteometer, err error) {
-	method := "tasteometer.compare"
+func (lfm *LastFM) CompareTaste(ctx context.Context, user1 string, user2 string) (taste *Tasteometer, err error) {
 	query := map[string]string{
 		"type1":  "user",
 		"type2":  "user",
 		"value1": user1,
 		"value2": user2}
 
-	if data, err := lfm.cacheGet(method, query); data != nil {
-		switch v := data.(type) {
-		case Tasteometer:
-			return &v, err
-		case *Tasteometer:
-			return v, err
-		}
-	} else if err != nil {
-		return nil, err
-	}
-
-	body, hdr, err := lfm.doQuery(method, query)
-	if err != nil {
-		return
-	}
-	defer body.Close()
-
-	status := lfmStatus{}
-	err = xml.NewDecoder(body).Decode(&status)
-	if err != nil {
-		return
-	}
-	if status.Error.Code != 0 {
-		err = &status.Error
-		go lfm.cacheSet(method, query, err, hdr)
-		return
-	}
-
-	taste = &status.Tasteometer
-	go lfm.cacheSet(method, query, taste, hdr)
-	return
+	return call(ctx, lfm, "tasteometer.compare", query, func(s *lfmStatus) *Tasteometer { return &s.Tasteometer })
 }
 
 type Neighbour struct {
@@ -133,37 +95,15 @@ type Neighbours []Neighbour
 // that has high tasteometer comparison scores.
 //
 // See http://www.last.fm/api/show/user.getNeighbours
-func (lfm *LastFM) GetUserNeighbours(user string, limit int) (neighbours Neighbours, err error) {
-	method := "user.getNeighbours"
+func (lfm *LastFM) GetUserNeighbours(ctx context.Context, user string, limit int) (neighbours Neighbours, err error) {
 	query := map[string]string{
 		"user":  user,
 		"limit": strconv.Itoa(limit)}
 
-	if data, err := lfm.cacheGet(method, query); data != nil {
-		return data.(Neighbours), err
-	} else if err != nil {
-		return nil, err
+	n, err := call(ctx, lfm, "user.getNeighbours", query, func(s *lfmStatus) *Neighbours { return &s.Neighbours })
+	if n != nil {
+		neighbours = *n
 	}
-
-	body, hdr, err := lfm.doQuery(method, query)
-	if err != nil {
-		return
-	}
-	defer body.Close()
-
-	status := lfmStatus{}
-	err = xml.NewDecoder(body).Decode(&status)
-	if err != nil {
-		return
-	}
-	if status.Error.Code != 0 {
-		err = &status.Error
-		go lfm.cacheSet(method, query, err, hdr)
-		return
-	}
-
-	neighbours = status.Neighbours
-	go lfm.cacheSet(method, query, neighbours, hdr)
 	return
 }
 
@@ -190,10 +130,23 @@ func (p Period) String() string {
 	return periodStringMap[p]
 }
 
+// Returns the Period with the given name, or 0 if there's none.
+func parsePeriod(name string) Period {
+	for k, v := range periodStringMap {
+		if name == v {
+			return k
+		}
+	}
+	return 0
+}
+
 type TopArtists struct {
-	User   string `xml:"user,attr"`
-	Period Period `xml:"-"`
-	Total  int    `xml:"total,attr"`
+	User       string `xml:"user,attr"`
+	Period     Period `xml:"-"`
+	Total      int    `xml:"total,attr"`
+	Page       int    `xml:"page,attr"`
+	PerPage    int    `xml:"perPage,attr"`
+	TotalPages int    `xml:"totalPages,attr"`
 
 	Artists []Artist `xml:"artist"`
 
@@ -202,57 +155,352 @@ type TopArtists struct {
 }
 
 func (top *TopArtists) unmarshalHelper() (err error) {
-	for k, v := range periodStringMap {
-		if top.RawPeriod == v {
-			top.Period = k
-			break
-		}
-	}
+	top.Period = parsePeriod(top.RawPeriod)
 	return
 }
 
 // Gets a list of the (up to limit) most played artists of a user within a Period.
 //
 // See http://www.last.fm/api/show/user.getTopArtists.
-func (lfm *LastFM) GetUserTopArtists(user string, period Period, limit int) (top *TopArtists, err error) {
-	method := "user.getTopArtists"
+func (lfm *LastFM) GetUserTopArtists(ctx context.Context, user string, period Period, limit int) (top *TopArtists, err error) {
 	query := map[string]string{
 		"user":   user,
 		"period": periodStringMap[period],
 		"limit":  strconv.Itoa(limit)}
 
-	if data, err := lfm.cacheGet(method, query); data != nil {
-		switch v := data.(type) {
-		case TopArtists:
-			return &v, err
-		case *TopArtists:
-			return v, err
+	return call(ctx, lfm, "user.getTopArtists", query, func(s *lfmStatus) *TopArtists { return &s.TopArtists })
+}
+
+type TopTrack struct {
+	Rank      int           `xml:"rank,attr"`
+	Name      string        `xml:"name"`
+	MBID      string        `xml:"mbid"`
+	URL       string        `xml:"url"`
+	PlayCount int           `xml:"playcount"`
+	Listeners int           `xml:"listeners"` // Not present in GetUserTopTracks
+	Duration  time.Duration `xml:"-"`
+	Artist    Artist        `xml:"artist"`
+	Images    Images        `xml:"image"`
+
+	// Only present in the results of GetSimilarTracks
+	Match float32 `xml:"match"`
+
+	// For internal use
+	RawDuration int `xml:"duration"` // In seconds
+}
+
+func (track *TopTrack) unmarshalHelper() (err error) {
+	track.Duration = time.Duration(track.RawDuration) * time.Second
+	return
+}
+
+// The most played tracks of a user or, in GetArtistTopTracks, an artist.
+type TopTracks struct {
+	User       string `xml:"user,attr"`
+	Artist     string `xml:"artist,attr"`
+	Period     Period `xml:"-"`
+	Total      int    `xml:"total,attr"`
+	Page       int    `xml:"page,attr"`
+	PerPage    int    `xml:"perPage,attr"`
+	TotalPages int    `xml:"totalPages,attr"`
+
+	Tracks []TopTrack `xml:"track"`
+
+	// For internal use
+	RawPeriod string `xml:"type,attr"`
+}
+
+func (top *TopTracks) unmarshalHelper() (err error) {
+	top.Period = parsePeriod(top.RawPeriod)
+	for i := range top.Tracks {
+		if err = top.Tracks[i].unmarshalHelper(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Gets a page of up to limit of the most played tracks of a user within a Period.
+// Pages start at 1.
+//
+// See http://www.last.fm/api/show/user.getTopTracks.
+func (lfm *LastFM) GetUserTopTracks(ctx context.Context, user string, period Period, page, limit int) (top *TopTracks, err error) {
+	query := map[string]string{
+		"user":   user,
+		"period": periodStringMap[period],
+		"page":   strconv.Itoa(page),
+		"limit":  strconv.Itoa(limit)}
+
+	return call(ctx, lfm, "user.getTopTracks", query, func(s *lfmStatus) *TopTracks { return &s.TopTracks })
+}
+
+type TopAlbum struct {
+	Rank      int    `xml:"rank,attr"`
+	Name      string `xml:"name"`
+	MBID      string `xml:"mbid"`
+	URL       string `xml:"url"`
+	PlayCount int    `xml:"playcount"`
+	Artist    Artist `xml:"artist"`
+	Images    Images `xml:"image"`
+}
+
+type TopAlbums struct {
+	User       string `xml:"user,attr"`
+	Period     Period `xml:"-"`
+	Total      int    `xml:"total,attr"`
+	Page       int    `xml:"page,attr"`
+	PerPage    int    `xml:"perPage,attr"`
+	TotalPages int    `xml:"totalPages,attr"`
+
+	Albums []TopAlbum `xml:"album"`
+
+	// For internal use
+	RawPeriod string `xml:"type,attr"`
+}
+
+func (top *TopAlbums) unmarshalHelper() (err error) {
+	top.Period = parsePeriod(top.RawPeriod)
+	return
+}
+
+// Gets a page of up to limit of the most played albums of a user within a Period.
+// Pages start at 1.
+//
+// See http://www.last.fm/api/show/user.getTopAlbums.
+func (lfm *LastFM) GetUserTopAlbums(ctx context.Context, user string, period Period, page, limit int) (top *TopAlbums, err error) {
+	query := map[string]string{
+		"user":   user,
+		"period": periodStringMap[period],
+		"page":   strconv.Itoa(page),
+		"limit":  strconv.Itoa(limit)}
+
+	return call(ctx, lfm, "user.getTopAlbums", query, func(s *lfmStatus) *TopAlbums { return &s.TopAlbums })
+}
+
+// Gets up to limit of the tags a user applied the most. The returned struct's
+// .User is set instead of .Artist and .Track.
+//
+// See http://www.last.fm/api/show/user.getTopTags.
+func (lfm *LastFM) GetUserTopTags(ctx context.Context, user string, limit int) (toptags *TopTags, err error) {
+	query := map[string]string{
+		"user":  user,
+		"limit": strconv.Itoa(limit)}
+
+	return call(ctx, lfm, "user.getTopTags", query, func(s *lfmStatus) *TopTags { return &s.TopTags })
+}
+
+type LovedTracks struct {
+	User       string `xml:"user,attr"`
+	Total      int    `xml:"total,attr"`
+	Page       int    `xml:"page,attr"`
+	PerPage    int    `xml:"perPage,attr"`
+	TotalPages int    `xml:"totalPages,attr"`
+
+	Tracks []Track `xml:"track"` // .Date is when the track was loved
+}
+
+func (loved *LovedTracks) unmarshalHelper() (err error) {
+	for i := range loved.Tracks {
+		loved.Tracks[i].Loved = true
+		if err = loved.Tracks[i].unmarshalHelper(); err != nil {
+			return
 		}
-	} else if err != nil {
-		return nil, err
 	}
+	return
+}
 
-	body, hdr, err := lfm.doQuery(method, query)
-	if err != nil {
-		return
+// Gets a page of up to limit of the tracks a user loved, the most recently
+// loved first. Pages start at 1.
+//
+// See http://www.last.fm/api/show/user.getLovedTracks.
+func (lfm *LastFM) GetUserLovedTracks(ctx context.Context, user string, page, limit int) (loved *LovedTracks, err error) {
+	query := map[string]string{
+		"user":  user,
+		"page":  strconv.Itoa(page),
+		"limit": strconv.Itoa(limit)}
+
+	return call(ctx, lfm, "user.getLovedTracks", query, func(s *lfmStatus) *LovedTracks { return &s.LovedTracks })
+}
+
+type UserInfo struct {
+	Name       string    `xml:"name"`
+	RealName   string    `xml:"realname"`
+	URL        string    `xml:"url"`
+	Country    string    `xml:"country"`
+	Age        int       `xml:"age"`    // 0 if not given
+	Gender     string    `xml:"gender"` // "m", "f" or "n" if not given
+	Subscriber bool      `xml:"subscriber"`
+	PlayCount  int       `xml:"playcount"`
+	Playlists  int       `xml:"playlists"`
+	Registered time.Time `xml:"-"`
+	Images     Images    `xml:"image"`
+
+	// For internal use
+	RawRegistered struct {
+		Unixtime int64 `xml:"unixtime,attr"`
+	} `xml:"registered"`
+}
+
+func (info *UserInfo) unmarshalHelper() (err error) {
+	if info.RawRegistered.Unixtime != 0 {
+		info.Registered = time.Unix(info.RawRegistered.Unixtime, 0)
 	}
-	defer body.Close()
+	return
+}
 
-	status := lfmStatus{}
-	err = xml.NewDecoder(body).Decode(&status)
-	if err != nil {
-		return
+// Gets the profile of a user.
+//
+// See http://www.last.fm/api/show/user.getInfo.
+func (lfm *LastFM) GetUserInfo(ctx context.Context, user string) (info *UserInfo, err error) {
+	query := map[string]string{
+		"user": user}
+
+	return call(ctx, lfm, "user.getInfo", query, func(s *lfmStatus) *UserInfo { return &s.UserInfo })
+}
+
+type Friends struct {
+	User       string `xml:"user,attr"`
+	Total      int    `xml:"total,attr"`
+	Page       int    `xml:"page,attr"`
+	PerPage    int    `xml:"perPage,attr"`
+	TotalPages int    `xml:"totalPages,attr"`
+
+	Friends []UserInfo `xml:"user"`
+}
+
+func (friends *Friends) unmarshalHelper() (err error) {
+	for i := range friends.Friends {
+		if err = friends.Friends[i].unmarshalHelper(); err != nil {
+			return
+		}
 	}
-	if status.Error.Code != 0 {
-		err = &status.Error
-		go lfm.cacheSet(method, query, err, hdr)
-		return
+	return
+}
+
+// Gets a page of up to limit of a user's friends. Pages start at 1.
+//
+// See http://www.last.fm/api/show/user.getFriends.
+func (lfm *LastFM) GetUserFriends(ctx context.Context, user string, page, limit int) (friends *Friends, err error) {
+	query := map[string]string{
+		"user":  user,
+		"page":  strconv.Itoa(page),
+		"limit": strconv.Itoa(limit)}
+
+	return call(ctx, lfm, "user.getFriends", query, func(s *lfmStatus) *Friends { return &s.Friends })
+}
+
+// The week a weekly chart covers.
+type ChartRange struct {
+	From time.Time `xml:"-"`
+	To   time.Time `xml:"-"`
+
+	// For internal use
+	RawFrom int64 `xml:"from,attr"`
+	RawTo   int64 `xml:"to,attr"`
+}
+
+func (r *ChartRange) unmarshalHelper() (err error) {
+	r.From, r.To = time.Unix(r.RawFrom, 0), time.Unix(r.RawTo, 0)
+	return
+}
+
+// Adds the range to a weekly chart query, unless it's the zero ChartRange.
+func (r *ChartRange) addParams(query map[string]string) {
+	if !r.From.IsZero() || !r.To.IsZero() {
+		query["from"] = strconv.FormatInt(r.From.Unix(), 10)
+		query["to"] = strconv.FormatInt(r.To.Unix(), 10)
 	}
+}
 
-	top = &status.TopArtists
-	err = top.unmarshalHelper()
-	if err == nil {
-		go lfm.cacheSet(method, query, top, hdr)
+type WeeklyChartList struct {
+	User   string       `xml:"user,attr"`
+	Charts []ChartRange `xml:"chart"` // The oldest first
+}
+
+func (list *WeeklyChartList) unmarshalHelper() (err error) {
+	for i := range list.Charts {
+		if err = list.Charts[i].unmarshalHelper(); err != nil {
+			return
+		}
 	}
 	return
 }
+
+// Gets the weeks for which a user has weekly charts, which can be given to
+// GetUserWeeklyArtistChart, GetUserWeeklyAlbumChart and GetUserWeeklyTrackChart.
+//
+// See http://www.last.fm/api/show/user.getWeeklyChartList.
+func (lfm *LastFM) GetUserWeeklyChartList(ctx context.Context, user string) (list *WeeklyChartList, err error) {
+	query := map[string]string{
+		"user": user}
+
+	return call(ctx, lfm, "user.getWeeklyChartList", query, func(s *lfmStatus) *WeeklyChartList { return &s.WeeklyChartList })
+}
+
+// An artist given by name, with its MBID as an attribute, as in weekly album
+// and track charts.
+type ChartArtist struct {
+	Name string `xml:",chardata"`
+	MBID string `xml:"mbid,attr"`
+}
+
+type ChartEntry struct {
+	Rank      int         `xml:"rank,attr"`
+	Name      string      `xml:"name"`
+	MBID      string      `xml:"mbid"`
+	URL       string      `xml:"url"`
+	PlayCount int         `xml:"playcount"`
+	Artist    ChartArtist `xml:"artist"` // Not set in artist charts
+	Images    Images      `xml:"image"`  // Only in track charts
+}
+
+// A user's weekly artist, album or track chart.
+type WeeklyChart struct {
+	User string `xml:"user,attr"`
+	ChartRange
+
+	Entries []ChartEntry `xml:",any"` // The artists, albums or tracks, the most played first
+}
+
+func (chart *WeeklyChart) unmarshalHelper() (err error) {
+	return chart.ChartRange.unmarshalHelper()
+}
+
+// Gets a weekly chart; see the GetUserWeekly*Chart methods.
+func (lfm *LastFM) getWeeklyChart(ctx context.Context, method string, user string, week ChartRange, result func(*lfmStatus) *WeeklyChart) (chart *WeeklyChart, err error) {
+	query := map[string]string{
+		"user": user}
+	week.addParams(query)
+
+	return call(ctx, lfm, method, query, result)
+}
+
+// Gets the artists a user played the most in a week, as given by
+// GetUserWeeklyChartList. If week is the zero ChartRange, gets the most
+// recent week.
+//
+// See http://www.last.fm/api/show/user.getWeeklyArtistChart.
+func (lfm *LastFM) GetUserWeeklyArtistChart(ctx context.Context, user string, week ChartRange) (chart *WeeklyChart, err error) {
+	return lfm.getWeeklyChart(ctx, "user.getWeeklyArtistChart", user, week,
+		func(s *lfmStatus) *WeeklyChart { return &s.WeeklyArtistChart })
+}
+
+// Gets the albums a user played the most in a week, as given by
+// GetUserWeeklyChartList. If week is the zero ChartRange, gets the most
+// recent week.
+//
+// See http://www.last.fm/api/show/user.getWeeklyAlbumChart.
+func (lfm *LastFM) GetUserWeeklyAlbumChart(ctx context.Context, user string, week ChartRange) (chart *WeeklyChart, err error) {
+	return lfm.getWeeklyChart(ctx, "user.getWeeklyAlbumChart", user, week,
+		func(s *lfmStatus) *WeeklyChart { return &s.WeeklyAlbumChart })
+}
+
+// Gets the tracks a user played the most in a week, as given by
+// GetUserWeeklyChartList. If week is the zero ChartRange, gets the most
+// recent week.
+//
+// See http://www.last.fm/api/show/user.getWeeklyTrackChart.
+func (lfm *LastFM) GetUserWeeklyTrackChart(ctx context.Context, user string, week ChartRange) (chart *WeeklyChart, err error) {
+	return lfm.getWeeklyChart(ctx, "user.getWeeklyTrackChart", user, week,
+		func(s *lfmStatus) *WeeklyChart { return &s.WeeklyTrackChart })
+}
@@ -1,8 +1,10 @@
 package lastfm_test
 
 import (
+	"context"
 	"github.com/Kovensky/go-lastfm"
 	"testing"
+	"time"
 )
 
 // TODO: more coverage?
@@ -10,10 +12,11 @@ import (
 func TestGetRecentTracks(T *testing.T) {
 	T.Parallel()
 	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
-	tracks, err := lfm.GetRecentTracks("Kovensky", 1)
+	tracks, err := lfm.GetRecentTracks(context.Background(), "Kovensky", 1)
 
 	if Expect(T, "error", nil, err) {
 		Expect(T, "scrobble count", 39679, tracks.Total)
+		Expect(T, "page count", 39679, tracks.TotalPages)
 		Expect(T, "now playing track", &tracks.Tracks[0], tracks.NowPlaying)
 		Expect(T, "first track's loved status to be", true, tracks.Tracks[0].Loved)
 	}
@@ -22,7 +25,7 @@ func TestGetRecentTracks(T *testing.T) {
 func TestCompareTaste(T *testing.T) {
 	T.Parallel()
 	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
-	taste, err := lfm.CompareTaste("Kovensky", "D4RK-PH0ENIX")
+	taste, err := lfm.CompareTaste(context.Background(), "Kovensky", "D4RK-PH0ENIX")
 
 	if Expect(T, "error", nil, err) {
 		Expect(T, "artist count", 5, len(taste.Artists))
@@ -33,7 +36,7 @@ func TestCompareTaste(T *testing.T) {
 func TestGetUserNeighbours(T *testing.T) {
 	T.Parallel()
 	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
-	n, err := lfm.GetUserNeighbours("Kovensky", 1)
+	n, err := lfm.GetUserNeighbours(context.Background(), "Kovensky", 1)
 
 	if Expect(T, "error", nil, err) {
 		Expect(T, "neighbour count", 1, len(n))
@@ -44,7 +47,7 @@ func TestGetUserNeighbours(T *testing.T) {
 func TestGetUserTopArtists(T *testing.T) {
 	T.Parallel()
 	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
-	t, err := lfm.GetUserTopArtists("Kovensky", lastfm.Overall, 1)
+	t, err := lfm.GetUserTopArtists(context.Background(), "Kovensky", lastfm.Overall, 1)
 
 	if Expect(T, "error", nil, err) {
 		Expect(T, "user", "Kovensky", t.User)
@@ -54,3 +57,133 @@ func TestGetUserTopArtists(T *testing.T) {
 		}
 	}
 }
+
+func TestGetRecentTracks_Canceled(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := lfm.GetRecentTracks(ctx, "Kovensky", 1)
+
+	Expect(T, "error", context.Canceled, err)
+}
+
+func TestGetUserTopTracks(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	t, err := lfm.GetUserTopTracks(context.Background(), "Kovensky", lastfm.Overall, 1, 2)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "period", lastfm.Overall, t.Period)
+		Expect(T, "page count", 7415, t.TotalPages)
+		if Expect(T, "track count", 2, len(t.Tracks)) {
+			Expect(T, "second track's rank", 2, t.Tracks[1].Rank)
+			Expect(T, "top track", "Aerodynamic", t.Tracks[0].Name)
+			Expect(T, "top track's artist", "Daft Punk", t.Tracks[0].Artist.Name)
+			Expect(T, "top track's duration", 212*time.Second, t.Tracks[0].Duration)
+		}
+	}
+}
+
+func TestGetUserTopAlbums(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	t, err := lfm.GetUserTopAlbums(context.Background(), "Kovensky", lastfm.OneWeek, 1, 2)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "period", lastfm.OneWeek, t.Period)
+		Expect(T, "album total", 23, t.Total)
+		if Expect(T, "album count", 2, len(t.Albums)) {
+			Expect(T, "top album", "Random Access Memories", t.Albums[0].Name)
+			Expect(T, "top album's play count", 64, t.Albums[0].PlayCount)
+		}
+	}
+}
+
+func TestGetUserTopTags(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	t, err := lfm.GetUserTopTags(context.Background(), "Kovensky", 2)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "user", "Kovensky", t.User)
+		if Expect(T, "tag count", 2, len(t.Tags)) {
+			Expect(T, "second tag", "visual kei", t.Tags[1].Name)
+		}
+	}
+}
+
+func TestGetUserLovedTracks(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	loved, err := lfm.GetUserLovedTracks(context.Background(), "Kovensky", 1, 2)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "loved track total", 277, loved.Total)
+		if Expect(T, "track count", 2, len(loved.Tracks)) {
+			Expect(T, "last loved track", "Motherboard", loved.Tracks[0].Name)
+			Expect(T, "last loved track's loved status to be", true, loved.Tracks[0].Loved)
+			Expect(T, "last loved track's date", int64(1368900511), loved.Tracks[0].Date.Unix())
+		}
+	}
+}
+
+func TestGetUserInfo(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	info, err := lfm.GetUserInfo(context.Background(), "Kovensky")
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "name", "Kovensky", info.Name)
+		Expect(T, "play count", 39679, info.PlayCount)
+		Expect(T, "subscriber status to be", true, info.Subscriber)
+		Expect(T, "registration date", int64(1180993587), info.Registered.Unix())
+	}
+}
+
+func TestGetUserFriends(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	friends, err := lfm.GetUserFriends(context.Background(), "Kovensky", 1, 2)
+
+	if Expect(T, "error", nil, err) {
+		Expect(T, "user", "Kovensky", friends.User)
+		Expect(T, "page count", 9, friends.TotalPages)
+		if Expect(T, "friend count", 2, len(friends.Friends)) {
+			Expect(T, "second friend", "D4RK-PH0ENIX", friends.Friends[1].Name)
+			Expect(T, "second friend's registration date", int64(1170175200), friends.Friends[1].Registered.Unix())
+		}
+	}
+}
+
+func TestGetUserWeeklyCharts(T *testing.T) {
+	T.Parallel()
+	lfm := lastfm.Mock(lastfm.New("4c563adf68bc357a4570d3e7986f6481"))
+	list, err := lfm.GetUserWeeklyChartList(context.Background(), "Kovensky")
+	if !Expect(T, "error", nil, err) || !Expect(T, "chart count", 2, len(list.Charts)) {
+		return
+	}
+	week := list.Charts[0]
+	Expect(T, "first week's end", int64(1368360000), week.To.Unix())
+
+	artists, err := lfm.GetUserWeeklyArtistChart(context.Background(), "Kovensky", lastfm.ChartRange{})
+	if Expect(T, "error", nil, err) && Expect(T, "artist count", 2, len(artists.Entries)) {
+		Expect(T, "latest week's start", list.Charts[1].From, artists.From)
+		Expect(T, "top artist", "Daft Punk", artists.Entries[0].Name)
+		Expect(T, "second artist's play count", 31, artists.Entries[1].PlayCount)
+	}
+
+	albums, err := lfm.GetUserWeeklyAlbumChart(context.Background(), "Kovensky", week)
+	if Expect(T, "error", nil, err) && Expect(T, "album count", 1, len(albums.Entries)) {
+		Expect(T, "week's start", week.From, albums.From)
+		Expect(T, "top album", "Discovery", albums.Entries[0].Name)
+		Expect(T, "top album's artist", "Daft Punk", albums.Entries[0].Artist.Name)
+	}
+
+	tracks, err := lfm.GetUserWeeklyTrackChart(context.Background(), "Kovensky", week)
+	if Expect(T, "error", nil, err) && Expect(T, "track count", 2, len(tracks.Entries)) {
+		Expect(T, "second track", "One More Time", tracks.Entries[1].Name)
+		Expect(T, "second track's rank", 2, tracks.Entries[1].Rank)
+		Expect(T, "second track's artist MBID", "056e4f3e-d505-4dad-8ec1-d04f521cbb56", tracks.Entries[1].Artist.MBID)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/fluffle/goirc/client"
+)
+
+// IRCv3 capabilities that tell which services account each user is logged in
+// to, so that identification can be checked without a WHOIS. account-tag is
+// not among them, as goirc can't parse lines with message tags.
+var accountCaps = []string{"account-notify", "extended-join"}
+
+// Tells the WHOX replies to the bot's own account queries apart from others.
+const whoxToken = "152"
+
+// Accounts are only tracked for users that share a channel with the bot, as
+// account-notify says nothing about the others. To keep things simple, users
+// are forgotten as soon as they leave any channel; the next check will use a
+// WHOIS instead.
+//
+// The results of WHOIS checks are forgotten here too, so that both are keyed
+// the same way and kept in step.
+func (n *Network) addAccountHandlers() {
+	n.irc.HandleFunc("JOIN", func(irc *client.Conn, line *client.Line) {
+		// extended-join: JOIN #channel account :realname
+		if len(line.Args) >= 2 {
+			n.setAccount("extended-join", line.Nick, line.Args[1])
+		}
+		// The users already in the channel didn't join after the bot
+		if line.Nick == irc.Me().Nick && len(line.Args) >= 1 {
+			s := n.session()
+			s.Lock()
+			whox := s.whox && s.enabled["account-notify"]
+			if whox {
+				s.whoxChannels[strings.ToLower(line.Args[0])] = true
+			}
+			s.Unlock()
+			if whox {
+				irc.Raw("WHO " + line.Args[0] + " %tna," + whoxToken)
+			}
+		}
+	})
+	n.irc.HandleFunc("005", func(irc *client.Conn, line *client.Line) {
+		for _, token := range line.Args {
+			if token == "WHOX" {
+				s := n.session()
+				s.Lock()
+				s.whox = true
+				s.Unlock()
+			}
+		}
+	})
+	// RPL_WHOSPCRPL: 354 me token nick account, with "0" when not logged in.
+	// Without account-notify, logouts wouldn't be seen, so the accounts are
+	// only recorded along with it.
+	n.irc.HandleFunc("354", func(irc *client.Conn, line *client.Line) {
+		if len(line.Args) >= 4 && line.Args[1] == whoxToken {
+			account := line.Args[3]
+			if account == "0" {
+				account = "*"
+			}
+			n.setAccount("account-notify", line.Args[2], account)
+		}
+	})
+	n.irc.HandleFunc("ACCOUNT", func(irc *client.Conn, line *client.Line) {
+		if len(line.Args) >= 1 {
+			n.setAccount("account-notify", line.Nick, line.Args[0])
+		}
+	})
+	forget := func(nick string) {
+		s := n.session()
+		s.Lock()
+		if strings.EqualFold(nick, n.irc.Me().Nick) {
+			s.accounts = make(map[string]string)
+		} else {
+			delete(s.accounts, strings.ToLower(nick))
+		}
+		s.Unlock()
+		n.forgetIdentified(nick)
+	}
+	n.irc.HandleFunc("PART", func(irc *client.Conn, line *client.Line) {
+		forget(line.Nick)
+	})
+	n.irc.HandleFunc("KICK", func(irc *client.Conn, line *client.Line) {
+		if len(line.Args) >= 2 {
+			forget(line.Args[1])
+		}
+	})
+	n.irc.HandleFunc("QUIT", func(irc *client.Conn, line *client.Line) {
+		forget(line.Nick)
+	})
+	n.irc.HandleFunc("NICK", func(irc *client.Conn, line *client.Line) {
+		if len(line.Args) == 0 {
+			return
+		}
+		s := n.session()
+		s.Lock()
+		old, nick := strings.ToLower(line.Nick), strings.ToLower(line.Args[0])
+		if account, ok := s.accounts[old]; ok {
+			delete(s.accounts, old)
+			s.accounts[nick] = account
+		}
+		s.Unlock()
+		// Whoever takes the old nick isn't identified as its previous owner
+		n.forgetIdentified(old, nick)
+	})
+}
+
+// Records the account of a nick, if the capability that reported it is
+// enabled. An account of "*" means the nick is not logged in.
+func (n *Network) setAccount(capability, nick, account string) {
+	if account == "*" {
+		account = ""
+	}
+	s := n.session()
+	s.Lock()
+	if !s.enabled[capability] {
+		s.Unlock()
+		return
+	}
+	s.accounts[strings.ToLower(nick)] = account
+	s.Unlock()
+
+	if account == "" {
+		// may have logged out after a successful WHOIS
+		n.forgetIdentified(nick)
+	}
+}
+
+// Reports whether the end of a WHO list for the channel is that of the WHOX
+// sent on joining it, so that it doesn't end a What's Playing request.
+func (n *Network) endAccountWho(channel string) bool {
+	s := n.session()
+	s.Lock()
+	defer s.Unlock()
+	key := strings.ToLower(channel)
+	if !s.whoxChannels[key] {
+		return false
+	}
+	delete(s.whoxChannels, key)
+	return true
+}
+
+// Forgets the WHOIS checks of the nicks, so that they're checked again.
+func (n *Network) forgetIdentified(nicks ...string) {
+	n.isIdentifiedMutex.Lock()
+	for _, nick := range nicks {
+		delete(n.isIdentifiedCache, strings.ToLower(nick))
+	}
+	n.isIdentifiedMutex.Unlock()
+}
+
+// Returns the services account the nick is logged in to, or "" if it's not
+// logged in, and whether that is known without a WHOIS.
+func (n *Network) account(nick string) (account string, known bool) {
+	s := n.session()
+	s.Lock()
+	defer s.Unlock()
+	account, known = s.accounts[strings.ToLower(nick)]
+	return
+}
@@ -1,21 +1,33 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
-	"log"
 	"strings"
+	"time"
 
 	"github.com/fluffle/goirc/client"
 )
 
-func addWhoHandlers(irc *client.Conn) {
-	if !flag.Parsed() {
-		flag.Parse()
-	}
+func init() {
+	RegisterCommand(&Command{
+		Name:        "wp",
+		Help:        "Shows what's playing for everyone in the channel.",
+		RequireAuth: true,
+		Hidden:      true, // Not listed in .help, to not encourage abuse
+		Timeout:     2 * time.Minute,
+		Cooldown: func() time.Duration {
+			return time.Duration(getConfig().Throttle.WPCooldown)
+		},
+		Handler: func(n *Network, req *Request) {
+			n.reportAllNowPlaying(req.Context(), req.Nick, req.Target)
+		},
+	})
+}
 
-	irc.HandleFunc("352", whoHandler)
-	irc.HandleFunc("315", whoHandler)
+func (n *Network) addWhoHandlers() {
+	n.irc.HandleFunc("352", n.whoHandler)
+	n.irc.HandleFunc("315", n.whoHandler)
 	return
 }
 
@@ -23,76 +35,105 @@ func addWhoHandlers(irc *client.Conn) {
 // >> :irc.cccp-project.net 352 Ziltoid #qqkthx kovensky Rizon-180E162B.bluebottle.net.au * Kov|abx G :0 Diogo Franco
 // >> :irc.cccp-project.net 315 Ziltoid #qqkthx :End of /WHO list.
 
-var whoHandlerLimit = make(chan bool, 1)
-
-func whoHandler(irc *client.Conn, line *client.Line) {
-	whoHandlerLimit <- true
+func (n *Network) whoHandler(irc *client.Conn, line *client.Line) {
+	n.whoMutex.Lock()
+	defer n.whoMutex.Unlock()
 
+	// Servers may echo the channel in another case than it was asked with
+	channel := strings.ToLower(line.Args[1])
 	switch line.Cmd {
 	case "352":
-		if _, ok := whoResult[line.Args[1]]; !ok {
-			log.Println("Creating result slice for channel", line.Args[1])
-			whoResult[line.Args[1]] = []string{}
+		// Replies to a WHO that isn't ours, or that was given up on
+		if _, ok := n.whoChannel[channel]; !ok {
+			break
 		}
-		whoResult[line.Args[1]] = append(whoResult[line.Args[1]], line.Args[5])
+		n.whoResult[channel] = append(n.whoResult[channel], line.Args[5])
 	case "315":
-		log.Println("End of WHO for channel", line.Args[1])
-		close(whoChannel[line.Args[1]])
+		n.Println("End of WHO for channel", line.Args[1])
+		if n.endAccountWho(channel) {
+			break
+		}
+		// Left for reportAllNowPlaying to delete, along with the result
+		if c, ok := n.whoChannel[channel]; ok {
+			select {
+			case <-c: // already ended
+			default:
+				close(c)
+			}
+		}
 	}
-	<-whoHandlerLimit
 	return
 }
 
-var (
-	whoChannel = make(map[string]chan bool)
-	whoResult  = make(map[string][]string)
-	rateLimit  = make(chan bool, 6)
-)
+// Limits how many nicks are queried at once, across all networks.
+var rateLimit = make(chan bool, 6)
 
-func reportAllNowPlaying(irc *client.Conn, asker, channel string) {
+func (n *Network) reportAllNowPlaying(ctx context.Context, asker, channel string) {
 	if !(strings.HasPrefix(channel, "#") || strings.HasPrefix(channel, "&")) {
-		log.Println("User", asker, "asked What's Playing...... via PM")
-		irc.Privmsg(channel, fmt.Sprintf("%s: this only works on channels", asker))
+		n.Println("User", asker, "asked What's Playing...... via PM")
+		n.reply(channel, fmt.Sprintf("%s: this only works on channels", asker))
 		return
 	}
-	log.Println("User", asker, "requested What's Playing on channel", channel)
+	n.Println("User", asker, "requested What's Playing on channel", channel)
 
-	if !checkIdentified(irc, asker) {
-		r := fmt.Sprintf("%s: you must be identified with NickServ to use this command", asker)
-		log.Println(r)
-		irc.Privmsg(channel, r)
+	key := strings.ToLower(channel)
+	n.whoMutex.Lock()
+	if _, ok := n.whoChannel[key]; ok {
+		n.whoMutex.Unlock()
+		n.Println("Channel", channel, "is already executing a What's Playing request")
 		return
 	}
-
-	if _, ok := whoChannel[channel]; ok {
-		log.Println("Channel", channel, "is already executing a What's Playing request")
+	done := make(chan bool)
+	n.whoChannel[key] = done
+	n.whoResult[key] = nil
+	n.whoMutex.Unlock()
+
+	go n.irc.Who(channel)
+	ended := true
+	select {
+	case <-done: // closed by whoHandler
+	case <-ctx.Done():
+		n.Println("Gave up waiting for the WHO reply for", channel)
+		ended = false
+	}
+	n.whoMutex.Lock()
+	result := n.whoResult[key]
+	delete(n.whoChannel, key)
+	delete(n.whoResult, key)
+	n.whoMutex.Unlock()
+	if !ended {
 		return
 	}
 
-	whoChannel[channel] = make(chan bool, 1)
-
-	go irc.Who(channel)
-	for _ = range whoChannel[channel] { // wait until channel is closed
+	nicks := []string{}
+	for _, nick := range result {
+		if nick != n.irc.Me().Nick {
+			nicks = append(nicks, nick)
+		}
+	}
+	totalReport := len(nicks)
+	if totalReport == 0 {
+		n.Println("Nobody to report now playing for in channel", channel)
+		n.irc.Notice(asker, fmt.Sprintf("There's nobody else in %s", channel))
+		return
 	}
-	delete(whoChannel, channel)
-
 	reportChan := make(chan bool)
-	totalReport := len(whoResult[channel]) - 1
 	msg := fmt.Sprintf("Reporting now playing for %d nicks in channel %s", totalReport, channel)
-	log.Println(msg)
-	irc.Notice(asker, msg)
-
-	for _, nick := range whoResult[channel] {
-		if nick != irc.Me().Nick {
-			n := nick
-			go func() {
-				rateLimit <- true
-				reportChan <- reportNowPlaying(irc, channel, asker, n, true)
+	n.Println(msg)
+	n.irc.Notice(asker, msg)
+
+	for _, nick := range nicks {
+		nick := nick
+		go func() {
+			select {
+			case rateLimit <- true:
+				reportChan <- n.reportNowPlaying(ctx, channel, asker, nick, true)
 				<-rateLimit
-			}()
-		}
+			case <-ctx.Done():
+				reportChan <- false
+			}
+		}()
 	}
-	delete(whoResult, channel)
 
 	okCount, totalCount := 0, 0
 	for r := range reportChan {
@@ -106,8 +147,8 @@ func reportAllNowPlaying(irc *client.Conn, asker, channel string) {
 	close(reportChan)
 
 	msg = fmt.Sprintf("Reported for %d of %d nicks", okCount, totalCount)
-	log.Println(msg)
-	irc.Notice(asker, msg)
+	n.Println(msg)
+	n.irc.Notice(asker, msg)
 
 	return
 }
@@ -2,20 +2,21 @@ package main
 
 import (
 	"compress/zlib"
-	"crypto/tls"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"math"
 	"os"
 	"os/signal"
-	"regexp"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/Kovensky/go-lastfm"
-	"github.com/fluffle/goirc/client"
 )
 
 var (
@@ -26,152 +27,114 @@ var (
 	nickPass    = flag.String("nickserv-password", "", `A NickServ password to authenticate the bot, if any. Tested on Freenode and SynIRC.`)
 	channelList = flag.String("channels", "", `Comma-separated list of channels to join on the server. Required`)
 	apiKey      = flag.String("api-key", "", `The Last.fm API key. Required.`)
+	apiSecret   = flag.String("api-secret", "", `The Last.fm API shared secret. Needed to let users link their accounts with .link; also encrypts their session keys.`)
 	cmdPrefix   = flag.String("cmd-prefix", ".", `The prefix to user commands.`)
-	cacheFile   = flag.String("cache-file", "", `File used to persist the last.fm API cache. If blank, the cache is only kept in memory. Not multiprocess safe.`)
-	lfm         lastfm.LastFM
-	nickMap     = NewNickMap()
-	cacheTimer  *time.Timer
+	cacheFile   = flag.String("cache-file", "", `File used to persist the last.fm API cache. If blank, the cache is only kept in memory. Locked while the bot runs, so that other processes don't use it.`)
+	cacheType   = flag.String("cache-type", "memory", `How the last.fm API cache is kept: "memory", saved to -cache-file as a whole every so often; "lru", the same but holding up to -cache-size entries; or "disk", kept in -cache-file and updated as it changes.`)
+	cacheSize   = flag.Int("cache-size", 10000, `The most entries the "lru" cache holds.`)
+	configFile  = flag.String("config", "", `JSON configuration file. Settings missing from it default to the command line flags. Reloaded on SIGHUP.`)
+
+	commandTimeout  = flag.Duration("command-timeout", 30*time.Second, `How long a command may take before its Last.fm requests are canceled.`)
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, `How long to wait for the servers to acknowledge the QUIT when shutting down.`)
+
+	lfm        lastfm.LastFM
+	cacheTimer *time.Timer
+	cacheLock  io.Closer // Held while the bot runs, for the snapshot cache types
+
+	// Canceled when the bot shuts down, aborting the commands in progress.
+	botContext, stopBot = context.WithCancel(context.Background())
 )
 
-var whitespace = regexp.MustCompile(`\s+`)
-
-func onPrivmsg(irc *client.Conn, line *client.Line) {
-	words := whitespace.Split(line.Args[1], -1)
-	switch words[0] {
-	case *cmdPrefix + "wp":
-		go reportAllNowPlaying(irc, line.Nick, line.Args[0])
-	case *cmdPrefix + "np":
-		who := line.Nick
-		if len(words) > 1 && words[1] != "" {
-			who = words[1]
-		}
-		go reportNowPlaying(irc, line.Args[0], line.Nick, who, false)
-	case *cmdPrefix + "compare":
-		who := line.Nick
-		target := ""
-		if len(words) > 2 && words[2] != "" {
-			who = words[1]
-			target = words[2]
-		} else if len(words) > 1 {
-			target = words[1]
-		} else {
-			irc.Privmsg(line.Args[0], fmt.Sprintf("%s: tell me who to compare to!", line.Nick))
-			return
-		}
-		go doCompare(irc, line.Args[0], line.Nick, who, target)
-	case *cmdPrefix + "top5":
-		who := line.Nick
-		period := lastfm.Overall
-		if len(words) > 2 && words[2] != "" {
-			who = words[2]
-		}
-		if len(words) > 1 {
-			switch words[1] {
-			case "overall":
-				period = lastfm.Overall
-			case "year":
-				period = lastfm.OneYear
-			case "month":
-				period = lastfm.OneMonth
-			case "week":
-				period = lastfm.OneWeek
-			default:
-				irc.Privmsg(line.Args[0],
-					fmt.Sprintf("%s: usage: %stop5 ((overall|year|month|week) ($nick)?)?", line.Nick, *cmdPrefix))
-				return
-			}
-		}
-		go doTop5(irc, line.Args[0], line.Nick, period, who)
-	case *cmdPrefix + "setuser":
-		if len(words) < 2 || words[1] == "" {
-			irc.Privmsg(line.Args[0], fmt.Sprintf("%s: tell the username to associate with", line.Nick))
-			return
-		}
-		go nickMap.AddNick(irc, line.Args[0], line.Nick, words[1])
-	case *cmdPrefix + "ignore":
-		go nickMap.IgnoreNick(irc, line.Args[0], line.Nick)
-	case *cmdPrefix + "deluser":
-		go nickMap.DelNick(irc, line.Args[0], line.Nick)
-	case *cmdPrefix + "whois":
-		who := line.Nick
-		if len(words) > 1 && words[1] != "" {
-			who = words[1]
-		}
-		go nickMap.QueryNick(irc, line.Args[0], line.Nick, who)
-	case *cmdPrefix + "aka":
-		if len(words) < 2 {
-			irc.Privmsg(line.Args[0], fmt.Sprintf("%s: tell the username to know about", line.Nick))
-			return
-		}
-		go nickMap.ListAllNicks(irc, line.Args[0], line.Nick, words[1])
-	case *cmdPrefix + "help":
-		go sendHelp(irc, line.Nick)
-	}
+var top5Periods = []struct {
+	name   string
+	period lastfm.Period
+}{
+	{"overall", lastfm.Overall},
+	{"year", lastfm.OneYear},
+	{"6month", lastfm.SixMonths},
+	{"3month", lastfm.ThreeMonths},
+	{"month", lastfm.OneMonth},
+	{"week", lastfm.OneWeek},
 }
 
-var helpSplit = regexp.MustCompile(`\s{2,}`)
-
-func sendHelp(irc *client.Conn, nick string) {
-	helpStr := `
-	Last.fm commands:
-	` + *cmdPrefix + `np ($user)?: Shows your now playing song. If you give $user, queries for that $user.
-	` + *cmdPrefix + `compare ($user1) ($user2)?: Runs a tasteometer compare between you and $user1, or between $user1 and $user2 if present.
-	` + *cmdPrefix + `top5 ((overall|year|month|week) ($user)?)?: Shows the top5 artists in the chosen period for you or the $user.
-	` + *cmdPrefix + `whois ($nick)?: Shows your associated last.fm username, or the username associated with $nick.
-	` + *cmdPrefix + `aka ($username): Shows the nicks that have been associated with $username.
-	A nick can be used in place of a username if it's associated with a last.fm account.
-	`
-	if *requireAuth {
-		helpStr += `Commands that require that you be authenticated with NickServ:`
-	}
-	// There's also *cmdPrefix + "wp", but we don't document it to not encourage abuse.
-	helpStr += `
-	` + *cmdPrefix + `ignore: Makes the bot ignore you for most commands. Use ` +
-		*cmdPrefix + `setuser or ` + *cmdPrefix + `deluser to be unignored.
-	` + *cmdPrefix + `setuser ($username): Associates your nick with the given last.fm $username.
-	` + *cmdPrefix + `deluser: Removes your nick's association, if any.
-	` // + *cmdPrefix + `wp: Shows what's playing for everyone in the channel.` // uncomment this at your peril :)
-	for _, line := range helpSplit.Split(helpStr, -1) {
-		if line != "" {
-			irc.Notice(nick, line)
-		}
+func init() {
+	userArg := ArgSpec{Name: "$user", Optional: true, Help: "a last.fm username, or a nick associated with one"}
+
+	RegisterCommand(&Command{
+		Name: "np",
+		Help: "Shows your now playing song. If you give $user, queries for that $user.",
+		Args: []ArgSpec{userArg},
+		Handler: func(n *Network, req *Request) {
+			who := req.Nick
+			if len(req.Args) > 0 {
+				who = req.Args[0]
+			}
+			n.reportNowPlaying(req.Context(), req.Target, req.Nick, who, false)
+		},
+	})
+	RegisterCommand(&Command{
+		Name: "compare",
+		Help: "Runs a tasteometer compare between you and $user1, or between $user1 and $user2 if present.",
+		Args: []ArgSpec{
+			{Name: "$user1", Help: "the user to compare to"},
+			{Name: "$user2", Optional: true, Help: "if present, compares $user1 to $user2 instead of to you"},
+		},
+		Handler: func(n *Network, req *Request) {
+			who, target := req.Nick, req.Args[0]
+			if len(req.Args) > 1 {
+				who, target = req.Args[0], req.Args[1]
+			}
+			n.doCompare(req.Context(), req.Target, req.Nick, who, target)
+		},
+	})
+
+	periods := []string{}
+	for _, p := range top5Periods {
+		periods = append(periods, p.name)
 	}
+	RegisterCommand(&Command{
+		Name: "top5",
+		Help: "Shows the top5 artists in the chosen period for you or the $user.",
+		Args: []ArgSpec{
+			{Name: "$period", Optional: true, Choices: periods, Help: "defaults to overall"},
+			userArg,
+		},
+		Handler: func(n *Network, req *Request) {
+			who := req.Nick
+			period := lastfm.Overall
+			if len(req.Args) > 0 {
+				for _, p := range top5Periods {
+					if p.name == req.Args[0] {
+						period = p.period
+					}
+				}
+			}
+			if len(req.Args) > 1 {
+				who = req.Args[1]
+			}
+			n.doTop5(req.Context(), req.Target, req.Nick, period, who)
+		},
+	})
 }
 
-func reportIgnored(irc *client.Conn, asker, who string) {
+func (n *Network) reportIgnored(asker, who string) {
 	if asker == who {
-		irc.Notice(asker, "You asked to be ignored by last.fm commands")
+		n.irc.Notice(asker, "You asked to be ignored by last.fm commands")
 	} else {
-		irc.Notice(asker, fmt.Sprintf("%s asked to be ignored by last.fm commands", who))
-	}
-}
-
-func onInvite(irc *client.Conn, line *client.Line) {
-	who, channel := line.Args[0], line.Args[1]
-	log.Println(line.Nick, "invited bot to", channel)
-	if who == irc.Me().Nick {
-		// some IRCds only allow operators to INVITE, and on registered channels normally only identified users are operators
-		// check anyway, since there are some corner cases where that doesn't happen
-		if checkIdentified(irc, line.Nick) {
-			log.Println("Accepting invite to", channel)
-			irc.Join(channel)
-		} else {
-			irc.Notice(line.Nick, "you must be identified to invite")
-			log.Println("Ignoring invite, user is not identified")
-		}
+		n.irc.Notice(asker, fmt.Sprintf("%s asked to be ignored by last.fm commands", who))
 	}
 }
 
-func doTop5(irc *client.Conn, target, asker string, period lastfm.Period, user string) {
-	log.Println("Listing top", period, "5 artists for", user)
-	lfmUser, _ := nickMap.GetUser(user)
+func (n *Network) doTop5(ctx context.Context, target, asker string, period lastfm.Period, user string) {
+	n.Println("Listing top", period, "5 artists for", user)
+	lfmUser, _ := n.nickMap.GetUser(user)
 	if lfmUser == "" {
-		reportIgnored(irc, asker, user)
+		n.reportIgnored(asker, user)
 		return
 	}
-	top5, err := lfm.GetUserTopArtists(lfmUser, period, 5)
+	top5, err := lfm.GetUserTopArtists(ctx, lfmUser, period, 5)
 	if err != nil {
-		irc.Privmsg(target, fmt.Sprintf("[%s] %v", user, err))
+		n.reply(target, fmt.Sprintf("[%s] %v", user, err))
 		return
 	}
 	artists := []string{}
@@ -180,59 +143,57 @@ func doTop5(irc *client.Conn, target, asker string, period lastfm.Period, user s
 	}
 	r := fmt.Sprintf("[%s] %v top5: %s",
 		user, period, strings.Join(artists, ", "))
-	log.Println("Reply:", r)
-	irc.Privmsg(target, r)
+	n.Println("Reply:", r)
+	n.reply(target, r)
 	saveCache()
 }
 
-func doCompare(irc *client.Conn, target, asker, user1, user2 string) {
-	log.Println("Comparing", user1, "with", user2)
-	lfmUser1, _ := nickMap.GetUser(user1)
-	lfmUser2, _ := nickMap.GetUser(user2)
+func (n *Network) doCompare(ctx context.Context, target, asker, user1, user2 string) {
+	n.Println("Comparing", user1, "with", user2)
+	lfmUser1, _ := n.nickMap.GetUser(user1)
+	lfmUser2, _ := n.nickMap.GetUser(user2)
 	if lfmUser1 == "" || lfmUser2 == "" {
 		if lfmUser1 == "" {
-			reportIgnored(irc, asker, user1)
+			n.reportIgnored(asker, user1)
 		} else {
-			reportIgnored(irc, asker, user2)
+			n.reportIgnored(asker, user2)
 		}
 		return
 	}
-	taste, err := lfm.CompareTaste(lfmUser1, lfmUser2)
+	taste, err := lfm.CompareTaste(ctx, lfmUser1, lfmUser2)
 	if err != nil {
-		irc.Privmsg(target, fmt.Sprintf("[%s vs %s] %v", user1, user2, err))
+		n.reply(target, fmt.Sprintf("[%s vs %s] %v", user1, user2, err))
 		return
 	}
 	r := fmt.Sprintf("[%s vs %s] %.2f%% -- %s",
 		user1, user2, taste.Score*100, strings.Join(taste.Artists, ", "))
-	log.Println("Reply:", r)
-	irc.Privmsg(target, r)
+	n.Println("Reply:", r)
+	n.reply(target, r)
 	saveCache()
 }
 
-func reportNowPlaying(irc *client.Conn, target, asker, who string, onlyReportSuccess bool) bool {
-	log.Println("Reporting Now Playing for", who, "on channel", target)
-	user, _ := nickMap.GetUser(who)
+func (n *Network) reportNowPlaying(ctx context.Context, target, asker, who string, onlyReportSuccess bool) bool {
+	n.Println("Reporting Now Playing for", who, "on channel", target)
+	user, _ := n.nickMap.GetUser(who)
 	if user == "" {
 		if !onlyReportSuccess {
-			reportIgnored(irc, asker, who)
+			n.reportIgnored(asker, who)
 		}
 		return false
 	}
-	recent, err := lfm.GetRecentTracks(user, 1)
+	recent, err := lfm.GetRecentTracks(ctx, user, 1)
 	if err != nil {
 		extra := ""
-		lfmerr, ok := err.(*lastfm.LastFMError)
-		// lfmerr.Code is unreliable; a lot of things may be code 6...
-		if ok && lfmerr.Error() == "No user with that name was found" {
-			// plus their error messages are misleading...
+		if lastfm.IsNotFound(err) {
+			// last.fm's error messages are misleading...
 			extra = ", or user never scrobbled anything"
 		}
 		r := fmt.Sprintf("[%s] %v%s", who, err, extra)
 		if !onlyReportSuccess {
-			log.Println("Reply:", r)
-			irc.Privmsg(target, r)
+			n.Println("Reply:", r)
+			n.reply(target, r)
 		} else {
-			log.Println(r)
+			n.Println(r)
 		}
 		saveCache()
 		return false
@@ -241,7 +202,7 @@ func reportNowPlaying(irc *client.Conn, target, asker, who string, onlyReportSuc
 	if np != nil {
 		c := make(chan interface{})
 		go func() {
-			r, err := lfm.GetTrackInfo(*np, user, true)
+			r, err := lfm.GetTrackInfo(ctx, *np, user, true)
 			if err != nil {
 				c <- err
 			} else {
@@ -249,7 +210,7 @@ func reportNowPlaying(irc *client.Conn, target, asker, who string, onlyReportSuc
 			}
 		}()
 		go func() {
-			r, err := lfm.GetTrackTopTags(*np, true)
+			r, err := lfm.GetTrackTopTags(ctx, *np, true)
 			if err != nil {
 				c <- err
 			} else {
@@ -257,7 +218,7 @@ func reportNowPlaying(irc *client.Conn, target, asker, who string, onlyReportSuc
 			}
 		}()
 		go func() {
-			r, err := lfm.GetArtistTopTags(np.Artist, true)
+			r, err := lfm.GetArtistTopTags(ctx, np.Artist, true)
 			if err != nil {
 				c <- err
 			} else {
@@ -287,7 +248,7 @@ func reportNowPlaying(irc *client.Conn, target, asker, who string, onlyReportSuc
 						}
 					}
 				case error:
-					log.Println(err)
+					n.Println(r)
 				default:
 					panic(r)
 				}
@@ -304,32 +265,17 @@ func reportNowPlaying(irc *client.Conn, target, asker, who string, onlyReportSuc
 			}
 		}
 
-		reply := []string{
-			fmt.Sprintf("[%s] np: %s - %s", who, ti.Artist.Name, ti.Name)}
-		info := []string{}
-		if ti.UserLoved {
-			info = append(info, "<3")
-		}
-		if ti.UserPlaycount > 0 {
-			info = append(info, fmt.Sprintf("playcount %dx", ti.UserPlaycount))
-		} else {
-			info = append(info, "first listen")
-		}
-		reply = append(reply, fmt.Sprintf("[%s]", strings.Join(info, " - ")))
-
-		tags := []string{}
-		for i := 0; topTags != nil && i < 5 && i < len(topTags.Tags); i++ {
-			tags = append(tags, topTags.Tags[i].Name)
-		}
-		reply = append(reply, fmt.Sprintf("(%s)", strings.Join(tags, ", ")))
-
-		if ti.Duration != 0 {
-			reply = append(reply, fmt.Sprintf("[%v]", ti.Duration))
-		}
-
-		r := strings.Join(reply, " ")
-		log.Println("Reply:", r)
-		irc.Privmsg(target, r)
+		settings := n.channelSettings(target)
+		r := n.renderNowPlaying(&NowPlaying{
+			Nick:      who,
+			User:      user,
+			Verbosity: settings.Verbosity,
+			Track:     ti,
+			TopTags:   topTags,
+			Recent:    recent,
+		}, n.npFormats.Get(who), settings.NPFormat)
+		n.Println("Reply:", r)
+		n.reply(target, r)
 		saveCache()
 		return true
 	} else if len(recent.Tracks) > 0 && !onlyReportSuccess {
@@ -367,14 +313,14 @@ func reportNowPlaying(irc *client.Conn, target, asker, who string, onlyReportSuc
 			reply = append(reply, "not even last.fm knows when")
 		}
 		r := strings.Join(reply, " ")
-		log.Println("Reply:", r)
-		irc.Privmsg(target, r)
+		n.Println("Reply:", r)
+		n.reply(target, r)
 	} else if !onlyReportSuccess {
 		r := fmt.Sprintf("[%s] never scrobbled anything", who)
-		log.Println("Reply:", r)
-		irc.Privmsg(target, r)
+		n.Println("Reply:", r)
+		n.reply(target, r)
 	} else {
-		log.Printf("[%s] is not listening to anything\n", who)
+		n.Printf("[%s] is not listening to anything\n", who)
 	}
 	saveCache()
 	return false
@@ -383,15 +329,47 @@ func reportNowPlaying(irc *client.Conn, target, asker, who string, onlyReportSuc
 var sig chan os.Signal
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [check]\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "With the check command, validates the configuration and exits.\n\nOptions:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-	if *apiKey == "" {
-		log.Fatalln("Missing API key, provide one using -api-key")
+	switch flag.Arg(0) {
+	case "":
+	case "check":
+		checkConfig()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c, err := loadConfig()
+	if err != nil {
+		log.Fatalln("Configuration error:", err)
+	}
+	setConfig(c)
+
+	networks := []*Network{}
+	for _, cfg := range c.Networks {
+		networks = append(networks, NewNetwork(cfg))
+	}
+
+	lfm = lastfm.NewWithSecret(c.APIKey, c.APISecret)
+	lfm.Observer = func(e lastfm.Event) {
+		// delays are expected on .wp
+		if e.Kind != lastfm.RequestDelayed {
+			log.Printf("Last.fm request %s %s: %v", e.Method, e.Kind, e.Err)
+		}
+	}
+	for _, n := range networks {
+		n.nickMap.Load()
+		n.npFormats.Load()
+		n.sessions.Load()
 	}
-	lfm = lastfm.New(*apiKey)
-	loadNickMap()
 	loadCache()
 
-	if *cacheFile != "" {
+	if c.CacheFile != "" {
 		cacheTimer = time.NewTimer(120 * time.Second)
 		go func() {
 			for _ = range cacheTimer.C {
@@ -401,156 +379,145 @@ func main() {
 		}()
 	}
 
-	if *server == "" {
-		log.Fatalln("No server to connect to")
+	for _, n := range networks {
+		n.Connect()
 	}
-	if *channelList == "" {
-		log.Fatalln("No channels to join")
-	}
-
-	config := client.NewConfig(*botNick)
-	config.Version = "github.com/Kovensky/go-lastfm-bot"
-	config.SSL = *useSSL
-	config.Flood = false
-
-	// TODO: CA management?
-	config.SSLConfig = &tls.Config{InsecureSkipVerify: true}
 
-	irc := client.Client(config)
-
-	addNickHandlers(irc)
-	addWhoHandlers(irc)
-
-	irc.HandleFunc(client.CONNECTED, func(irc *client.Conn, line *client.Line) {
-		if *nickPass != "" {
-			if irc.Me().Nick != *botNick {
-				log.Println("Nick", *botNick, "was not available; trying to retake it")
-				irc.Privmsg("NickServ", fmt.Sprintf("GHOST %s %s", *botNick, *nickPass))
-			} else {
-				log.Println("Identifying with NickServ")
-				irc.Privmsg("NickServ", fmt.Sprintf("IDENTIFY %s", *nickPass))
-			}
-		}
-		log.Println("Connected; joining", *channelList)
-		irc.Join(*channelList)
-	})
-	irc.HandleFunc("NOTICE", func(irc *client.Conn, line *client.Line) {
-		if strings.ToLower(line.Nick) == "nickserv" {
-			log.Println("NickServ:", line.Args[1])
-			switch {
-			case strings.Contains(strings.ToLower(line.Args[1]), "ghost"):
-				log.Println("Ghost command successful")
-				log.Println("Changing nick to", *botNick)
-				irc.Nick(*botNick)
-			case strings.Contains(line.Args[1], "identified"),
-				strings.Contains(line.Args[1], "recognized"):
-				log.Println("Successfully identified with NickServ; joining", *channelList)
-				irc.Join(*channelList)
-			}
-		}
-	})
-	irc.HandleFunc("QUIT", func(irc *client.Conn, line *client.Line) {
-		if line.Nick == *botNick {
-			log.Println("Nick", *botNick, "now available, changing to it")
-			irc.Nick(*botNick)
-		}
-	})
-	irc.HandleFunc("NICK", func(irc *client.Conn, line *client.Line) {
-		if line.Args[len(line.Args)-1] == irc.Me().Nick {
-			log.Println("Nick successfully changed to", irc.Me().Nick)
-			if *nickPass != "" {
-				log.Println("Identifying with NickServ")
-				irc.Privmsg("NickServ", fmt.Sprintf("IDENTIFY %s", *nickPass))
-			}
+	sig = make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGHUP)
+	for s := range sig {
+		if s != syscall.SIGHUP {
+			break
 		}
-	})
-	irc.HandleFunc("332", func(irc *client.Conn, line *client.Line) {
-		log.Println("Joined", line.Args[1])
-	})
-	irc.HandleFunc("INVITE", onInvite)
-	irc.HandleFunc("PRIVMSG", onPrivmsg)
-
-	quitting := false
-	quit := make(chan bool)
-	irc.HandleFunc(client.DISCONNECTED, func(irc *client.Conn, line *client.Line) {
-		if quitting {
-			quit <- true
-			return
+		networks = reloadConfig(networks)
+	}
+	shutdown(networks)
+}
+
+// Cancels the commands in progress, disconnects from all networks and saves
+// everything. Gives up on the servers after the shutdown timeout, or right
+// away if another signal is received.
+func shutdown(networks []*Network) {
+	log.Println("Shutting down")
+	stopBot()
+
+	timeout := time.Duration(getConfig().ShutdownTimeout)
+	done := make(chan bool)
+	go func() {
+		wg := sync.WaitGroup{}
+		for _, n := range networks {
+			wg.Add(1)
+			go func(n *Network) {
+				n.Quit(timeout)
+				wg.Done()
+			}(n)
 		}
-		resetIdentifiedCache()
-		log.Println("Disconnected; waiting 10 seconds then reconnecting...")
-		saveCacheNow()
-		go func() {
-			time.Sleep(10 * time.Second)
-			errorCount := 0
-			for !irc.Connected() {
-				log.Println("Reconnecting...")
-				err := irc.ConnectTo(*server, *password)
-				if err != nil {
-					log.Println("Error reconnecting:", err)
-					// limited exponential backoff (10, 12, 14, 18, 26, 42, 74)
-					retryDuration := 10 + time.Duration(math.Pow(2, float64(errorCount)))*time.Second
-					if errorCount < 6 {
-						errorCount += 1
-					}
-					log.Println("Retrying in", retryDuration)
-					time.Sleep(retryDuration)
-				}
-			}
-		}()
-	})
-	if *useSSL {
-		log.Println("Using SSL")
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-sig:
+		log.Println("Not waiting for the servers")
 	}
-	log.Println("Connecting to", *server)
-	irc.ConnectTo(*server, *password)
 
-	sig = make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, os.Kill, syscall.SIGTERM)
-	<-sig
-	quitting = true
-	log.Println("Disconnecting")
-	irc.Quit("Exiting")
+	for _, n := range networks {
+		n.nickMap.Flush()
+	}
 	saveCacheNow()
-	<-quit // wait until the QUIT is sent to server
 }
 
+// Creates the last.fm API cache of the configured type, and loads it from the
+// cache file.
 func loadCache() {
-	if *cacheFile != "" {
-		if fh, err := os.Open(*cacheFile); err != nil {
-			log.Println("Error opening cache file:", err)
-		} else {
-			defer fh.Close()
-			zr, _ := zlib.NewReader(fh)
-			if err = lfm.LoadCache(zr); err != nil {
-				log.Println("Error reading cache file:", err)
-			} else {
-				log.Println("Loaded", lfm.Cache.ItemCount(), "cache entries")
-			}
+	c := getConfig()
+	switch c.CacheType {
+	case "disk":
+		cache, err := lastfm.OpenDiskCache(c.CacheFile)
+		if err != nil {
+			log.Fatalln("Error opening cache file:", err)
+		}
+		lfm.Cache = cache
+		log.Println("Opened cache with", cache.Stats().Items, "entries")
+		return
+	case "lru":
+		lfm.Cache = lastfm.NewLRUCache(c.CacheSize)
+	}
+
+	cacheFile := c.CacheFile
+	if cacheFile == "" {
+		return
+	}
+	var err error
+	if cacheLock, err = lastfm.LockCacheFile(cacheFile); err != nil {
+		log.Fatalln("Error locking cache file:", err)
+	}
+	if fh, err := os.Open(cacheFile); err != nil {
+		log.Println("Error opening cache file:", err)
+	} else {
+		defer fh.Close()
+		zr, err := zlib.NewReader(fh)
+		if err == nil {
+			err = lfm.LoadCache(zr)
 			zr.Close()
 		}
+		var loadErr *lastfm.CacheLoadError
+		switch {
+		case errors.As(err, &loadErr):
+			log.Println("Loaded", lfm.Cache.Stats().Items, "cache entries, skipped", loadErr.Skipped, "unreadable ones:", loadErr.Err)
+		case err != nil:
+			log.Println("Error reading cache file:", err)
+		default:
+			log.Println("Loaded", lfm.Cache.Stats().Items, "cache entries")
+		}
 	}
 }
 
 func saveCache() {
-	if *cacheFile != "" {
+	cacheFile := getConfig().CacheFile
+	if cacheFile != "" {
 		cacheTimer.Reset(10 * time.Second)
 	}
 }
 
 func saveCacheNow() {
-	if *cacheFile != "" {
-		if fh, err := os.Create(*cacheFile); err != nil {
-			log.Println("Error creating cache file:", err)
+	if cache, ok := lfm.Cache.(*lastfm.DiskCache); ok {
+		// already saved, as it changes
+		if err := cache.Sync(); err != nil {
+			log.Println("Error storing cache:", err)
+		}
+		return
+	}
+	cacheFile := getConfig().CacheFile
+	if cacheFile != "" {
+		if err := writeCacheFile(cacheFile); err != nil {
+			log.Println("Error storing cache:", err)
 		} else {
-			defer fh.Close()
-			zw, _ := zlib.NewWriterLevel(fh, zlib.BestCompression)
-			if err = lfm.SaveCache(zw); err != nil {
-				log.Println("Error storing cache:", err)
-			} else {
-				log.Println("Cache saved with", lfm.Cache.ItemCount(), "entries")
-			}
-			zw.Close()
+			log.Println("Cache saved with", lfm.Cache.Stats().Items, "entries;", lfm.CoalescedRequests(), "requests coalesced so far")
 		}
 	}
 }
+
+// Writes the cache to a temporary file that replaces the cache file once
+// complete, so that a crash or a full disk doesn't leave it half-written.
+func writeCacheFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly after the rename
+	zw, _ := zlib.NewWriterLevel(tmp, zlib.BestCompression)
+	if err = lfm.SaveCache(zw); err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
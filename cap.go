@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+var (
+	saslMechanism = flag.String("sasl", "auto", `SASL mechanism used to authenticate the bot: "plain", "external", "none", or "auto" to use EXTERNAL when a -ssl-cert is given, or PLAIN when a -nickserv-password is given. Falls back to NickServ IDENTIFY when the server doesn't offer SASL.`)
+	saslUser      = flag.String("sasl-user", "", `The services account to authenticate as with SASL. If blank, -nick is used.`)
+)
+
+// SASL mechanisms.
+const (
+	SASLAuto     = "auto"
+	SASLPlain    = "plain"
+	SASLExternal = "external"
+	SASLNone     = "none"
+)
+
+// How long to wait for NickServ to confirm the bot identified before joining
+// the channels anyway.
+const identifyTimeout = 30 * time.Second
+
+// The state of a single connection to the network: the capabilities that were
+// negotiated, whether the bot authenticated, and the accounts of other users.
+type session struct {
+	sync.Mutex
+	offered        map[string]string // The capabilities offered by the server, with their values
+	enabled        map[string]bool
+	pending        int  // Capability requests waiting for an answer
+	authenticating bool // Whether SASL authentication is in progress
+	negotiated     bool // Whether CAP END was sent
+	registered     bool // Whether the server finished registering the bot
+	authenticated  bool // Whether SASL authentication succeeded
+	joined         bool
+	whox           bool // Whether the server supports WHOX, from its ISUPPORT
+
+	// The services accounts of users, by lowercased nick; "" for users known
+	// not to be logged in. See account.go.
+	accounts map[string]string
+	// Channels with a WHOX for accounts underway, by lowercased channel
+	whoxChannels map[string]bool
+}
+
+func newSession() *session {
+	return &session{
+		offered:      make(map[string]string),
+		enabled:      make(map[string]bool),
+		accounts:     make(map[string]string),
+		whoxChannels: make(map[string]bool),
+	}
+}
+
+// Ends capability negotiation once all requests were answered and SASL is
+// done. Must be called with the session locked.
+func (s *session) endNegotiation(irc *client.Conn) {
+	if s.pending == 0 && !s.authenticating && !s.negotiated {
+		s.negotiated = true
+		irc.Raw("CAP END")
+	}
+}
+
+// Returns the session of the current connection.
+func (n *Network) session() *session {
+	n.sessionMutex.Lock()
+	defer n.sessionMutex.Unlock()
+	return n.sess
+}
+
+// Returns the SASL mechanism to use with the current settings, or "" if SASL
+// is not to be used.
+func (cfg *NetworkConfig) mechanism() string {
+	switch cfg.SASL {
+	case SASLAuto:
+		switch {
+		case cfg.SSL && cfg.SSLCert != "":
+			return SASLExternal
+		case cfg.NickServPassword != "":
+			return SASLPlain
+		}
+		return ""
+	case SASLNone:
+		return ""
+	}
+	return cfg.SASL
+}
+
+func (n *Network) addCapHandlers() {
+	n.irc.HandleFunc(client.REGISTER, func(irc *client.Conn, line *client.Line) {
+		n.sessionMutex.Lock()
+		n.sess = newSession()
+		n.sessionMutex.Unlock()
+		// goirc has already sent NICK and USER by now; servers usually still
+		// hold registration for their ident and DNS lookups, and then until
+		// CAP END. If one doesn't, the capabilities are negotiated anyway,
+		// and the bot identifies with NickServ instead of SASL.
+		irc.Raw("CAP LS 302")
+	})
+	n.irc.HandleFunc("CAP", n.onCap)
+	n.irc.HandleFunc("AUTHENTICATE", n.onAuthenticate)
+	for _, numeric := range []string{"900", "903", "902", "904", "905", "906", "907", "908"} {
+		n.irc.HandleFunc(numeric, n.onSASLResult)
+	}
+}
+
+// Returns the capabilities the bot would like to enable.
+func (n *Network) wantedCaps(s *session) []string {
+	caps := []string{}
+	// SASL is only possible during registration
+	if mech := n.Config().mechanism(); mech != "" && !s.registered {
+		if mechs, ok := s.offered["sasl"]; !ok {
+			n.Println("Server doesn't support SASL")
+		} else if mechs != "" && !containsFold(strings.Split(mechs, ","), mech) {
+			n.Println("Server doesn't support SASL", strings.ToUpper(mech), "only", mechs)
+		} else {
+			caps = append(caps, "sasl")
+		}
+	}
+	for _, c := range accountCaps {
+		if _, ok := s.offered[c]; ok {
+			caps = append(caps, c)
+		}
+	}
+	return caps
+}
+
+func (n *Network) onCap(irc *client.Conn, line *client.Line) {
+	if len(line.Args) < 3 {
+		return
+	}
+	s := n.session()
+	s.Lock()
+	defer s.Unlock()
+	caps := strings.Fields(line.Args[len(line.Args)-1])
+	switch line.Args[1] {
+	case "LS":
+		for _, c := range caps {
+			kv := strings.SplitN(c, "=", 2)
+			s.offered[kv[0]] = ""
+			if len(kv) > 1 {
+				s.offered[kv[0]] = kv[1]
+			}
+		}
+		if len(line.Args) > 3 && line.Args[2] == "*" {
+			return // more to come
+		}
+		if s.negotiated {
+			return
+		}
+		// Requested one at a time, as the server refuses a whole request
+		// if it refuses any of its capabilities
+		want := n.wantedCaps(s)
+		if len(want) > 0 {
+			n.Println("Requesting capabilities:", strings.Join(want, " "))
+		}
+		for _, c := range want {
+			irc.Raw("CAP REQ :" + c)
+		}
+		s.pending = len(want)
+		s.endNegotiation(irc)
+	case "ACK":
+		for _, c := range caps {
+			if strings.HasPrefix(c, "-") {
+				delete(s.enabled, c[1:])
+			} else {
+				s.enabled[c] = true
+			}
+		}
+		n.Println("Enabled capabilities:", strings.Join(caps, " "))
+		if s.pending > 0 {
+			s.pending--
+		}
+		if containsFold(caps, "sasl") && !s.authenticated {
+			mech := n.Config().mechanism()
+			n.Println("Authenticating with SASL", strings.ToUpper(mech))
+			s.authenticating = true
+			irc.Raw("AUTHENTICATE " + strings.ToUpper(mech))
+		}
+		s.endNegotiation(irc)
+	case "NAK":
+		n.Println("Server refused capabilities:", strings.Join(caps, " "))
+		if s.pending > 0 {
+			s.pending--
+		}
+		s.endNegotiation(irc)
+	case "NEW", "DEL":
+		n.Println("Server capabilities changed:", line.Args[1], strings.Join(caps, " "))
+	}
+}
+
+func (n *Network) onAuthenticate(irc *client.Conn, line *client.Line) {
+	if len(line.Args) < 1 || line.Args[0] != "+" {
+		return
+	}
+	cfg := n.Config()
+	switch cfg.mechanism() {
+	case SASLPlain:
+		user := cfg.SASLUser
+		if user == "" {
+			user = cfg.Nick
+		}
+		resp := base64.StdEncoding.EncodeToString([]byte(user + "\x00" + user + "\x00" + cfg.NickServPassword))
+		// responses are sent in chunks of 400 bytes; one that is an exact
+		// multiple of that is followed by an empty one
+		for len(resp) >= 400 {
+			irc.Raw("AUTHENTICATE " + resp[:400])
+			resp = resp[400:]
+		}
+		if resp == "" {
+			resp = "+"
+		}
+		irc.Raw("AUTHENTICATE " + resp)
+	case SASLExternal:
+		irc.Raw("AUTHENTICATE +")
+	default:
+		irc.Raw("AUTHENTICATE *")
+	}
+}
+
+func (n *Network) onSASLResult(irc *client.Conn, line *client.Line) {
+	s := n.session()
+	s.Lock()
+	defer s.Unlock()
+	msg := line.Args[len(line.Args)-1]
+	switch line.Cmd {
+	case "900", "908": // RPL_LOGGEDIN, RPL_SASLMECHS
+		n.Println("SASL:", msg)
+		return
+	case "903": // RPL_SASLSUCCESS
+		n.Println("Successfully authenticated with SASL")
+		s.authenticated = true
+	case "907": // ERR_SASLALREADY
+		s.authenticated = true
+	default:
+		n.Println("SASL authentication failed:", msg)
+	}
+	s.authenticating = false
+	s.endNegotiation(irc)
+}
+
+// Called once the bot is registered. Authenticates with NickServ if SASL
+// wasn't used, and joins the channels once authenticated.
+func (n *Network) onConnected(irc *client.Conn, line *client.Line) {
+	s := n.session()
+	s.Lock()
+	s.registered = true
+	authenticated := s.authenticated
+	s.Unlock()
+
+	cfg := n.Config()
+	if cfg.NickServPassword != "" && irc.Me().Nick != cfg.Nick {
+		// If not authenticated yet, the bot identifies once the nick is retaken
+		n.Println("Nick", cfg.Nick, "was not available; trying to retake it")
+		irc.Privmsg("NickServ", fmt.Sprintf("GHOST %s %s", cfg.Nick, cfg.NickServPassword))
+	}
+	if authenticated || cfg.NickServPassword == "" {
+		n.Println("Connected; joining channels")
+		n.joinChannelsOnce(s)
+		return
+	}
+	if irc.Me().Nick == cfg.Nick {
+		n.Println("Identifying with NickServ")
+		irc.Privmsg("NickServ", fmt.Sprintf("IDENTIFY %s", cfg.NickServPassword))
+	}
+	// NickServ's replies vary between networks, so don't wait for them forever
+	time.AfterFunc(identifyTimeout, func() {
+		if n.session() == s && n.irc.Connected() && n.joinChannelsOnce(s) {
+			n.Println("NickServ didn't confirm identification; joined channels anyway")
+		}
+	})
+}
+
+// Joins the channels unless that was already done in this session. Returns
+// whether it joined them.
+func (n *Network) joinChannelsOnce(s *session) bool {
+	s.Lock()
+	joined := s.joined
+	s.joined = true
+	s.Unlock()
+	if joined {
+		return false
+	}
+	n.joinChannels()
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	replyMode = flag.String("reply-mode", "privmsg", `How to reply in channels: "privmsg" or "notice".`)
+	verbosity = flag.String("verbosity", "normal", `How much detail to show in replies: "short", "normal" or "long".`)
+)
+
+// Reply verbosity levels.
+const (
+	VerbosityShort  = "short"  // Only the essentials, such as the artist and track
+	VerbosityNormal = "normal" // The default
+	VerbosityLong   = "long"   // Adds details such as the album
+)
+
+// The settings in effect for a channel, after falling back to the global
+// configuration for anything the channel doesn't set.
+type ChannelSettings struct {
+	Prefix    string
+	Allow     map[string]bool // If not empty, only these commands are enabled
+	Deny      map[string]bool
+	Notice    bool // Reply with NOTICEs instead of PRIVMSGs
+	Verbosity string
+	NPFormat  string // The template used by np
+}
+
+// Whether the command is enabled with these settings.
+func (s *ChannelSettings) Enabled(cmd *Command) bool {
+	if s.Deny[cmd.Name] {
+		return false
+	}
+	return len(s.Allow) == 0 || s.Allow[cmd.Name]
+}
+
+// Checks the per-channel settings that can be set at any level.
+func checkChannelSettings(where, reply, verbosity, npFormat string, allow, deny []string) error {
+	switch reply {
+	case "", "privmsg", "notice":
+	default:
+		return fmt.Errorf("%s: invalid reply mode %q", where, reply)
+	}
+	switch verbosity {
+	case "", VerbosityShort, VerbosityNormal, VerbosityLong:
+	default:
+		return fmt.Errorf("%s: invalid verbosity %q", where, verbosity)
+	}
+	if npFormat != "" {
+		if err := checkNPFormat(npFormat); err != nil {
+			return fmt.Errorf("%s: invalid np_format: %v", where, err)
+		}
+	}
+	for _, name := range append(allow, deny...) {
+		if commands.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown command %q", where, name)
+		}
+	}
+	return nil
+}
+
+func commandSet(names []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range names {
+		// use the command's main name so that aliases are handled too
+		if cmd := commands.Lookup(name); cmd != nil {
+			set[cmd.Name] = true
+		}
+	}
+	return set
+}
+
+// Returns the settings for the given channel. Private messages, and channels
+// without a section in the configuration, use the network's or the global
+// settings.
+func (n *Network) channelSettings(channel string) *ChannelSettings {
+	c := getConfig()
+	s := &ChannelSettings{
+		Prefix:    c.CmdPrefix,
+		Notice:    c.ReplyMode == "notice",
+		Verbosity: c.Verbosity,
+		NPFormat:  c.NPFormat,
+	}
+	if format := n.Config().NPFormat; format != "" {
+		s.NPFormat = format
+	}
+	for _, ch := range n.Config().Channels {
+		if !strings.EqualFold(ch.Name, channel) {
+			continue
+		}
+		if ch.Prefix != "" {
+			s.Prefix = ch.Prefix
+		}
+		if ch.ReplyMode != "" {
+			s.Notice = ch.ReplyMode == "notice"
+		}
+		if ch.Verbosity != "" {
+			s.Verbosity = ch.Verbosity
+		}
+		if ch.NPFormat != "" {
+			s.NPFormat = ch.NPFormat
+		}
+		s.Allow = commandSet(ch.Allow)
+		s.Deny = commandSet(ch.Deny)
+		break
+	}
+	return s
+}
+
+// Sends a reply to the target, as a NOTICE if the target is a channel set to
+// use them, or as a PRIVMSG otherwise.
+func (n *Network) reply(target, msg string) {
+	if isChannel(target) && n.channelSettings(target).Notice {
+		n.irc.Notice(target, msg)
+	} else {
+		n.irc.Privmsg(target, msg)
+	}
+}
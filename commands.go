@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+// Describes a single argument accepted by a Command.
+type ArgSpec struct {
+	Name     string   // Shown in usage strings, e.g. "$user"
+	Help     string   // Shown by .help <command>
+	Optional bool     // Optional arguments may only be followed by other optional arguments
+	Choices  []string // If not empty, the argument must be one of these
+}
+
+// A command that users can invoke by sending a message starting with the command prefix.
+type Command struct {
+	Name        string    // The name that invokes the command, without the prefix
+	Aliases     []string  // Alternative names that also invoke the command
+	Help        string    // Short description used by .help
+	Args        []ArgSpec // Arguments the command accepts; extra words are passed along unchecked
+	RequireAuth bool      // Whether the user must be identified with NickServ when the network requires it
+	Hidden      bool      // Whether the command is left out of .help
+
+	// Whether the command acts with the Last.fm session linked to the nick.
+	// The user must then be identified with NickServ even when the network
+	// doesn't require it, as anyone could take the nick otherwise.
+	UsesSession bool
+
+	// If not nil, returns how long each channel has to wait between uses of this
+	// command, on top of the usual throttling.
+	Cooldown func() time.Duration
+
+	// How long the command may take before its Last.fm requests are canceled.
+	// If zero, the configured command_timeout is used.
+	Timeout time.Duration
+
+	// Called in its own goroutine once the arguments were checked and the user
+	// was authenticated, if needed.
+	Handler func(n *Network, req *Request)
+}
+
+// A single invocation of a Command.
+type Request struct {
+	Command *Command
+	Nick    string   // The nick that sent the command
+	Target  string   // Where replies should go; the channel, or the nick itself for private messages
+	Args    []string // The words following the command name
+	Text    string   // The text following the command name, with its spacing intact
+	Line    *client.Line
+	Account string // The services account of Nick, for commands that need identification
+
+	// The settings of the channel the command was sent to
+	Settings *ChannelSettings
+
+	ctx context.Context
+}
+
+// Returns the request's context, which is canceled when the command times
+// out or the bot shuts down.
+func (req *Request) Context() context.Context {
+	if req.ctx == nil {
+		return botContext
+	}
+	return req.ctx
+}
+
+// Returns the argument syntax of the command, such as "($user1) ($user2)?".
+func (cmd *Command) Usage() string {
+	usage := ""
+	for i := len(cmd.Args) - 1; i >= 0; i-- {
+		arg := cmd.Args[i]
+		name := arg.Name
+		if len(arg.Choices) > 0 {
+			name = "(" + strings.Join(arg.Choices, "|") + ")"
+		}
+		switch {
+		case arg.Optional && usage == "":
+			usage = "(" + name + ")?"
+		case arg.Optional:
+			usage = "(" + name + " " + usage + ")?"
+		case len(arg.Choices) > 0:
+			usage = strings.TrimSpace(name + " " + usage)
+		default:
+			usage = strings.TrimSpace("(" + name + ") " + usage)
+		}
+	}
+	return usage
+}
+
+// Checks the given arguments against the command's ArgSpecs.
+func (cmd *Command) checkArgs(args []string) bool {
+	for i, spec := range cmd.Args {
+		if i >= len(args) {
+			return spec.Optional
+		}
+		if len(spec.Choices) == 0 {
+			continue
+		}
+		valid := false
+		for _, choice := range spec.Choices {
+			if args[i] == choice {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the user must be identified with NickServ to use the command on a
+// network with the given settings.
+func (cmd *Command) needsAuth(cfg *NetworkConfig) bool {
+	return cmd.UsesSession || cmd.RequireAuth && cfg.RequireAuth
+}
+
+func (cmd *Command) run(n *Network, req *Request) {
+	timeout := cmd.Timeout
+	if timeout == 0 {
+		timeout = time.Duration(getConfig().CommandTimeout)
+	}
+	ctx, cancel := context.WithTimeout(botContext, timeout)
+	defer cancel()
+	req.ctx = ctx
+
+	if cmd.needsAuth(n.Config()) {
+		if req.Account = n.identifiedAccount(req.Nick); req.Account == "" {
+			r := fmt.Sprintf("%s: you must be identified with NickServ to use this command", req.Nick)
+			n.Println(r)
+			n.reply(req.Target, r)
+			return
+		}
+	}
+	if !n.checkCooldown(req) {
+		return
+	}
+	cmd.Handler(n, req)
+	if ctx.Err() == context.DeadlineExceeded {
+		n.Println("Command", cmd.Name, "from", req.Nick, "timed out after", timeout)
+	}
+}
+
+type CommandRegistry struct {
+	commands []*Command
+	names    map[string]*Command
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{names: make(map[string]*Command)}
+}
+
+// Adds a command to the registry. Panics if the name or one of the aliases is
+// already taken, as that can only be a programming error.
+func (r *CommandRegistry) Register(cmd *Command) {
+	for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+		if _, ok := r.names[name]; ok {
+			panic("command " + name + " registered twice")
+		}
+		r.names[name] = cmd
+	}
+	r.commands = append(r.commands, cmd)
+}
+
+// Finds a command by its name or by one of its aliases.
+func (r *CommandRegistry) Lookup(name string) *Command {
+	return r.names[name]
+}
+
+// Returns all registered commands, sorted by name.
+func (r *CommandRegistry) Commands() []*Command {
+	cmds := make([]*Command, len(r.commands))
+	copy(cmds, r.commands)
+	sort.Sort(commandsByName(cmds))
+	return cmds
+}
+
+type commandsByName []*Command
+
+func (c commandsByName) Len() int           { return len(c) }
+func (c commandsByName) Less(i, j int) bool { return c[i].Name < c[j].Name }
+func (c commandsByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+var commands = NewCommandRegistry()
+
+// Adds a command to the global registry; meant to be called from init functions.
+func RegisterCommand(cmd *Command) {
+	commands.Register(cmd)
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func isChannel(target string) bool {
+	return strings.HasPrefix(target, "#") || strings.HasPrefix(target, "&")
+}
+
+func (n *Network) onPrivmsg(irc *client.Conn, line *client.Line) {
+	words := []string{}
+	for _, word := range whitespace.Split(line.Args[1], -1) {
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	target := line.Args[0]
+	if !isChannel(target) {
+		target = line.Nick
+	}
+	settings := n.channelSettings(target)
+	prefix := settings.Prefix
+	if len(words) == 0 || !strings.HasPrefix(words[0], prefix) {
+		return
+	}
+	cmd := commands.Lookup(strings.TrimPrefix(words[0], prefix))
+	if cmd == nil || !settings.Enabled(cmd) {
+		return
+	}
+
+	req := &Request{
+		Command:  cmd,
+		Nick:     line.Nick,
+		Target:   target,
+		Args:     words[1:],
+		Text:     strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line.Args[1]), words[0])),
+		Line:     line,
+		Settings: settings,
+	}
+	if !cmd.checkArgs(req.Args) {
+		n.reply(req.Target, fmt.Sprintf("%s: usage: %s", req.Nick, strings.TrimSpace(prefix+cmd.Name+" "+cmd.Usage())))
+		return
+	}
+	if !n.checkThrottle(req) {
+		return
+	}
+	go cmd.run(n, req)
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name: "help",
+		Help: "Sends the command list through NOTICEs, or details about $command.",
+		Args: []ArgSpec{
+			{Name: "$command", Optional: true, Help: "the command to explain, with or without the prefix"},
+		},
+		Handler: func(n *Network, req *Request) {
+			if len(req.Args) > 0 {
+				n.sendCommandHelp(req.Nick, req.Settings, strings.TrimPrefix(req.Args[0], req.Settings.Prefix))
+			} else {
+				n.sendHelp(req.Nick, req.Settings)
+			}
+		},
+	})
+}
+
+func commandSummary(prefix string, cmd *Command) string {
+	summary := prefix + cmd.Name
+	if usage := cmd.Usage(); usage != "" {
+		summary += " " + usage
+	}
+	return summary + ": " + cmd.Help
+}
+
+// Sends the list of the commands enabled with the given settings.
+func (n *Network) sendHelp(nick string, settings *ChannelSettings) {
+	prefix := settings.Prefix
+	lines := []string{"Last.fm commands:"}
+	authLines := []string{}
+	for _, cmd := range commands.Commands() {
+		if cmd.Hidden || !settings.Enabled(cmd) {
+			continue
+		}
+		if cmd.needsAuth(n.Config()) {
+			authLines = append(authLines, commandSummary(prefix, cmd))
+		} else {
+			lines = append(lines, commandSummary(prefix, cmd))
+		}
+	}
+	lines = append(lines, "A nick can be used in place of a username if it's associated with a last.fm account.")
+	if len(authLines) > 0 {
+		lines = append(lines, "Commands that require that you be authenticated with NickServ:")
+		lines = append(lines, authLines...)
+	}
+	lines = append(lines, fmt.Sprintf("Use %shelp ($command) for details about a command.", prefix))
+	for _, line := range lines {
+		n.irc.Notice(nick, line)
+	}
+}
+
+func (n *Network) sendCommandHelp(nick string, settings *ChannelSettings, name string) {
+	prefix := settings.Prefix
+	cmd := commands.Lookup(name)
+	if cmd == nil || cmd.Hidden || !settings.Enabled(cmd) {
+		n.irc.Notice(nick, fmt.Sprintf("No such command: %s%s", prefix, name))
+		return
+	}
+	n.irc.Notice(nick, commandSummary(prefix, cmd))
+	for _, arg := range cmd.Args {
+		if arg.Help != "" {
+			n.irc.Notice(nick, fmt.Sprintf("  %s: %s", arg.Name, arg.Help))
+		}
+	}
+	if len(cmd.Aliases) > 0 {
+		aliases := make([]string, 0, len(cmd.Aliases))
+		for _, alias := range cmd.Aliases {
+			aliases = append(aliases, prefix+alias)
+		}
+		sort.Strings(aliases)
+		n.irc.Notice(nick, "Aliases: "+strings.Join(aliases, ", "))
+	}
+	if cmd.needsAuth(n.Config()) {
+		n.irc.Notice(nick, "Requires that you be authenticated with NickServ.")
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The bot's configuration. Every command line flag has a matching setting;
+// when a -config file is given, the flags provide the defaults for the
+// settings missing from the file.
+type Config struct {
+	APIKey    string          `json:"api_key"`    // Same as -api-key
+	APISecret string          `json:"api_secret"` // Same as -api-secret
+	CmdPrefix string          `json:"cmd_prefix"` // Same as -cmd-prefix
+	CacheFile string          `json:"cache_file"` // Same as -cache-file
+	CacheType string          `json:"cache_type"` // Same as -cache-type
+	CacheSize int             `json:"cache_size"` // Same as -cache-size
+	ReplyMode string          `json:"reply_mode"` // Same as -reply-mode
+	Verbosity string          `json:"verbosity"`  // Same as -verbosity
+	NPFormat  string          `json:"np_format"`  // Same as -np-format
+	Throttle  ThrottleConfig  `json:"throttle"`
+	Networks  []NetworkConfig `json:"networks"`
+
+	CommandTimeout  Duration `json:"command_timeout"`  // Same as -command-timeout
+	ShutdownTimeout Duration `json:"shutdown_timeout"` // Same as -shutdown-timeout
+}
+
+type ThrottleConfig struct {
+	Nick       RateLimit `json:"nick"`        // Same as -throttle-nick
+	Host       RateLimit `json:"host"`        // Same as -throttle-host
+	Channel    RateLimit `json:"channel"`     // Same as -throttle-channel
+	WPCooldown Duration  `json:"wp_cooldown"` // Same as -wp-cooldown
+}
+
+// Settings for a channel the bot joins on startup. Empty settings fall back
+// to the global ones.
+type ChannelConfig struct {
+	Name      string   `json:"name"`
+	Key       string   `json:"key"`        // The channel key (+k), if any
+	Prefix    string   `json:"cmd_prefix"` // Overrides the global cmd_prefix
+	Allow     []string `json:"allow"`      // If not empty, only these commands are enabled
+	Deny      []string `json:"deny"`       // These commands are disabled
+	ReplyMode string   `json:"reply_mode"` // Overrides the global reply_mode
+	Verbosity string   `json:"verbosity"`  // Overrides the global verbosity
+	NPFormat  string   `json:"np_format"`  // Overrides the network's np_format
+}
+
+// Accepts either a plain channel name or an object.
+func (ch *ChannelConfig) UnmarshalJSON(j []byte) error {
+	var name string
+	if err := json.Unmarshal(j, &name); err == nil {
+		*ch = ChannelConfig{Name: name}
+		return nil
+	}
+	type channelConfig ChannelConfig // avoids recursing into this method
+	return json.Unmarshal(j, (*channelConfig)(ch))
+}
+
+// A time.Duration that is written as a string such as "5m" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	dur, err := time.ParseDuration(string(text))
+	*d = Duration(dur)
+	return err
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (r *RateLimit) UnmarshalText(text []byte) error {
+	return r.Set(string(text))
+}
+
+func (r RateLimit) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+var (
+	config      *Config
+	configMutex sync.RWMutex
+)
+
+// Returns the configuration currently in effect. It may be replaced
+// at any time by a reload, so callers should not hold on to it.
+func getConfig() *Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return config
+}
+
+func setConfig(c *Config) {
+	configMutex.Lock()
+	config = c
+	configMutex.Unlock()
+}
+
+// Builds a Config from the command line flags alone.
+func flagConfig() *Config {
+	c := &Config{
+		APIKey:    *apiKey,
+		APISecret: *apiSecret,
+		CmdPrefix: *cmdPrefix,
+		CacheFile: *cacheFile,
+		CacheType: *cacheType,
+		CacheSize: *cacheSize,
+		ReplyMode: *replyMode,
+		Verbosity: *verbosity,
+		NPFormat:  *npFormat,
+
+		CommandTimeout:  Duration(*commandTimeout),
+		ShutdownTimeout: Duration(*shutdownTimeout),
+		Throttle: ThrottleConfig{
+			Nick:       nickLimit,
+			Host:       hostLimit,
+			Channel:    channelLimit,
+			WPCooldown: Duration(*wpCooldown),
+		},
+	}
+	if *server != "" || *channelList != "" {
+		c.Networks = []NetworkConfig{flagNetworkConfig()}
+	}
+	return c
+}
+
+// Reads a Config from a JSON file, using the command line flags as defaults.
+func readConfig(path string) (c *Config, err error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fh.Close()
+
+	// The networks are decoded separately so that each of them gets the defaults
+	var file struct {
+		Config
+		Networks []json.RawMessage `json:"networks"`
+	}
+	file.Config = *flagConfig()
+	if err = json.NewDecoder(fh).Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	c = &file.Config
+	if file.Networks == nil {
+		// Only global settings; the network comes from the flags, if any
+		return
+	}
+	c.Networks = nil
+	for i, r := range file.Networks {
+		cfg := flagNetworkConfig()
+		cfg.Server, cfg.Channels, cfg.NickFile, cfg.NPFormatFile, cfg.SessionFile = "", nil, "", "", ""
+		if err = json.Unmarshal(r, &cfg); err != nil {
+			return nil, fmt.Errorf("%s: network %d: %v", path, i+1, err)
+		}
+		c.Networks = append(c.Networks, cfg)
+	}
+	return
+}
+
+// Reads the configuration from -config if given, or from the flags otherwise,
+// and validates it.
+func loadConfig() (c *Config, err error) {
+	if *configFile == "" {
+		c = flagConfig()
+	} else if c, err = readConfig(*configFile); err != nil {
+		return
+	}
+	return c, c.validate()
+}
+
+// Fills in defaults and checks that the required settings are present.
+func (c *Config) validate() error {
+	if c.APIKey == "" {
+		return fmt.Errorf("missing API key, provide one using -api-key or api_key")
+	}
+	if c.CmdPrefix == "" || strings.ContainsAny(c.CmdPrefix, " \t") {
+		return fmt.Errorf("invalid command prefix %q", c.CmdPrefix)
+	}
+	if c.ReplyMode == "" || c.Verbosity == "" {
+		return fmt.Errorf("reply_mode and verbosity must not be empty")
+	}
+	if err := checkChannelSettings("global settings", c.ReplyMode, c.Verbosity, c.NPFormat, nil, nil); err != nil {
+		return err
+	}
+	switch c.CacheType {
+	case "memory":
+	case "lru":
+		if c.CacheSize <= 0 {
+			return fmt.Errorf("cache_size must be positive")
+		}
+	case "disk":
+		if c.CacheFile == "" {
+			return fmt.Errorf(`cache_type "disk" needs a cache_file`)
+		}
+	default:
+		return fmt.Errorf(`invalid cache_type %q, must be "memory", "lru" or "disk"`, c.CacheType)
+	}
+	if c.Throttle.WPCooldown < 0 {
+		return fmt.Errorf("negative wp_cooldown")
+	}
+	if c.CommandTimeout <= 0 || c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("command_timeout and shutdown_timeout must be positive")
+	}
+	if len(c.Networks) == 0 {
+		return fmt.Errorf("no server to connect to")
+	}
+	names := make(map[string]bool)
+	for i := range c.Networks {
+		if err := c.Networks[i].validate(); err != nil {
+			return err
+		}
+		name := strings.ToLower(c.Networks[i].Name)
+		if names[name] {
+			return fmt.Errorf("network %s is configured twice", c.Networks[i].Name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// Checks the configuration and exits; used by the "check" command.
+func checkConfig() {
+	c, err := loadConfig()
+	if err != nil {
+		log.Fatalln("Configuration error:", err)
+	}
+	for _, n := range c.Networks {
+		channels := []string{}
+		for _, ch := range n.Channels {
+			channels = append(channels, ch.Name)
+		}
+		log.Printf("Network %s: %s, joining %s", n.Name, n.Server, strings.Join(channels, ", "))
+	}
+	log.Println("Configuration OK")
+	os.Exit(0)
+}
+
+// Reads the configuration again and applies it to the running bot, connecting
+// to new networks and disconnecting from removed ones. Returns the networks
+// that are now running. If the new configuration is invalid, nothing changes.
+func reloadConfig(networks []*Network) []*Network {
+	if *configFile == "" {
+		log.Println("No -config file given; nothing to reload")
+		return networks
+	}
+	log.Println("Reloading", *configFile)
+	c, err := loadConfig()
+	if err != nil {
+		log.Println("Not reloading, configuration error:", err)
+		return networks
+	}
+	old := getConfig()
+	if c.APIKey != old.APIKey || c.APISecret != old.APISecret {
+		log.Println("Changing the API key or secret requires a restart")
+		c.APIKey, c.APISecret = old.APIKey, old.APISecret
+	}
+	if c.CacheFile != old.CacheFile || c.CacheType != old.CacheType || c.CacheSize != old.CacheSize {
+		log.Println("Changing the cache requires a restart")
+		c.CacheFile, c.CacheType, c.CacheSize = old.CacheFile, old.CacheType, old.CacheSize
+	}
+	setConfig(c)
+
+	running := make(map[string]*Network)
+	for _, n := range networks {
+		running[strings.ToLower(n.Config().Name)] = n
+	}
+	updated := []*Network{}
+	for _, cfg := range c.Networks {
+		name := strings.ToLower(cfg.Name)
+		if n, ok := running[name]; ok {
+			n.Reconfigure(cfg)
+			updated = append(updated, n)
+			delete(running, name)
+		} else {
+			n := NewNetwork(cfg)
+			n.nickMap.Load()
+			n.npFormats.Load()
+			n.sessions.Load()
+			n.Connect()
+			updated = append(updated, n)
+		}
+	}
+	for _, n := range running {
+		n.Println("Network removed from configuration")
+		go n.Quit(time.Duration(c.ShutdownTimeout))
+	}
+	log.Println("Configuration reloaded")
+	return updated
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kovensky/go-lastfm"
+)
+
+// How long a token from .link can be confirmed; Last.fm's limit.
+const linkTokenLifetime = 60 * time.Minute
+
+// Where users can revoke the bot's access to their account; there's no API
+// call to do it.
+const lastfmApplicationsURL = "https://www.last.fm/settings/applications"
+
+func init() {
+	RegisterCommand(&Command{
+		Name: "link",
+		Help: "Links your nick to your last.fm account, so that the bot can act on it. Send it in a private message, authorize the bot on last.fm, then send link confirm.",
+		Args: []ArgSpec{
+			{Name: "confirm", Optional: true, Choices: []string{"confirm"}, Help: "finishes linking, once you authorized the bot"},
+		},
+		UsesSession: true,
+		Handler: func(n *Network, req *Request) {
+			switch {
+			case getConfig().APISecret == "":
+				n.reply(req.Target, fmt.Sprintf("%s: linking accounts is not enabled", req.Nick))
+			case isChannel(req.Target):
+				n.irc.Notice(req.Nick, fmt.Sprintf("Send %slink to me in a private message", req.Settings.Prefix))
+			case len(req.Args) > 0:
+				n.confirmLink(req.Context(), req.Target, req.Nick, req.Account)
+			default:
+				n.startLink(req.Context(), req.Target, req.Nick, req.Account, req.Settings.Prefix)
+			}
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "unlink",
+		Help:        "Forgets the last.fm account linked with link. Your username association is kept.",
+		UsesSession: true,
+		Handler: func(n *Network, req *Request) {
+			n.sessions.Del(req.Target, req.Nick, req.Account)
+		},
+	})
+}
+
+func (n *Network) startLink(ctx context.Context, target, nick, account, prefix string) {
+	n.Println("Starting to link", nick, "as", account)
+	token, err := lfm.GetToken(ctx)
+	if err != nil {
+		r := fmt.Sprintf("[%s] %v", nick, err)
+		n.Println(r)
+		n.reply(target, r)
+		return
+	}
+	n.sessions.setToken(account, token)
+	n.reply(target, fmt.Sprintf("%s: authorize the bot at %s, then send %slink confirm within %v",
+		nick, lfm.AuthURL(token), prefix, linkTokenLifetime))
+}
+
+func (n *Network) confirmLink(ctx context.Context, target, nick, account string) {
+	token := n.sessions.token(account)
+	if token == "" {
+		n.reply(target, fmt.Sprintf("%s: use link first, or again if it's been more than %v", nick, linkTokenLifetime))
+		return
+	}
+	session, err := lfm.GetSession(ctx, token)
+	if err != nil {
+		r := fmt.Sprintf("[%s] %v", nick, err)
+		var code lastfm.ErrorCode
+		if errors.As(err, &code) {
+			switch code {
+			case lastfm.ErrUnauthorizedToken:
+				r = fmt.Sprintf("%s: you didn't authorize the bot yet; visit %s", nick, lfm.AuthURL(token))
+			case lastfm.ErrAuthenticationFailed, lastfm.ErrTokenExpired:
+				n.sessions.setToken(account, "")
+				r = fmt.Sprintf("%s: the authorization expired; use link again", nick)
+			}
+		}
+		n.Println(r)
+		n.reply(target, r)
+		return
+	}
+	n.sessions.setToken(account, "")
+	if err = n.sessions.Set(account, session); err != nil {
+		n.Println("Error storing session of", account+":", err)
+		n.reply(target, fmt.Sprintf("%s: couldn't store the session, try again later", nick))
+		return
+	}
+	// The session proves the nick owns the last.fm account
+	n.nickMap.Link(nick, session.User)
+
+	r := fmt.Sprintf("[%s] is now linked to last.fm user %s", nick, session.User)
+	n.Println(r)
+	n.reply(target, r)
+}
+
+// A session as stored in the network's SessionFile.
+type storedSession struct {
+	User string `json:"user"`
+	Key  string `json:"key"` // Encrypted with sessionCipher, in base64
+}
+
+// A pending .link, waiting for its confirmation.
+type linkToken struct {
+	token   string
+	expires time.Time
+}
+
+// The Last.fm sessions linked with .link, by lowercased services account, so
+// that they can be used from any nick logged in to the account, and not by
+// whoever takes the nick that linked them. The session keys are kept encrypted, both in memory and in the network's SessionFile, so that
+// they can't be used if the file leaks; the encryption key is derived from the
+// API secret, which signing calls with them needs anyway.
+type Sessions struct {
+	sessions map[string]storedSession
+	tokens   map[string]linkToken
+	network  *Network
+	sync.Mutex
+}
+
+func NewSessions(n *Network) *Sessions {
+	return &Sessions{
+		sessions: make(map[string]storedSession),
+		tokens:   make(map[string]linkToken),
+		network:  n}
+}
+
+// Loads the sessions from the network's SessionFile.
+func (s *Sessions) Load() {
+	n := s.network
+	cfg := n.Config()
+	if !cfg.SaveNicks {
+		return
+	}
+	fh, err := os.Open(cfg.SessionFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			n.Println("Error opening session file:", err)
+		}
+		return
+	}
+	defer fh.Close()
+	s.Lock()
+	defer s.Unlock()
+	if err = json.NewDecoder(fh).Decode(&s.sessions); err != nil {
+		n.Println("Error reading sessions:", err)
+	}
+}
+
+// Writes the sessions to the network's SessionFile. Must be called with the lock held.
+func (s *Sessions) save() {
+	n := s.network
+	if cfg := n.Config(); cfg.SaveNicks {
+		b, err := json.MarshalIndent(s.sessions, "", "\t")
+		if err != nil {
+			n.Println("Error marshaling sessions:", err)
+			return
+		}
+		if err = os.WriteFile(cfg.SessionFile, b, 0600); err != nil {
+			n.Println("Error writing session file:", err)
+		}
+	}
+}
+
+// Returns the session linked to the services account, or nil if there's none.
+func (s *Sessions) Get(account string) (*lastfm.Session, error) {
+	s.Lock()
+	stored, ok := s.sessions[strings.ToLower(account)]
+	s.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	key, err := decryptSessionKey(account, stored.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &lastfm.Session{User: stored.User, Key: key}, nil
+}
+
+func (s *Sessions) Set(account string, session *lastfm.Session) error {
+	key, err := encryptSessionKey(account, session.Key)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.sessions[strings.ToLower(account)] = storedSession{User: session.User, Key: key}
+	s.save()
+	s.Unlock()
+	return nil
+}
+
+func (s *Sessions) Del(target, nick, account string) {
+	s.Lock()
+	stored, ok := s.sessions[strings.ToLower(account)]
+	delete(s.sessions, strings.ToLower(account))
+	if ok {
+		s.save()
+	}
+	s.Unlock()
+
+	if !ok {
+		s.network.reply(target, fmt.Sprintf("%s: you didn't link a last.fm account", nick))
+		return
+	}
+	r := fmt.Sprintf("[%s] is no longer linked to last.fm user %s", nick, stored.User)
+	s.network.Println(r)
+	s.network.reply(target, r+"; to also revoke the bot's access, visit "+lastfmApplicationsURL)
+}
+
+// Returns the account's pending .link token, if it didn't expire.
+func (s *Sessions) token(account string) string {
+	s.Lock()
+	defer s.Unlock()
+	t := s.tokens[strings.ToLower(account)]
+	if time.Now().After(t.expires) {
+		delete(s.tokens, strings.ToLower(account))
+		return ""
+	}
+	return t.token
+}
+
+// Sets the account's pending .link token, or removes it if token is "".
+func (s *Sessions) setToken(account, token string) {
+	s.Lock()
+	defer s.Unlock()
+	if token == "" {
+		delete(s.tokens, strings.ToLower(account))
+	} else {
+		s.tokens[strings.ToLower(account)] = linkToken{token, time.Now().Add(linkTokenLifetime)}
+	}
+}
+
+// Returns the AEAD that encrypts session keys.
+func sessionCipher() (cipher.AEAD, error) {
+	secret := getConfig().APISecret
+	if secret == "" {
+		return nil, errors.New("no API secret")
+	}
+	key := sha256.Sum256([]byte("lastfm-bot session keys\x00" + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypts a session key; the account is authenticated along with it, so that
+// keys can't be moved to other accounts in the file.
+func encryptSessionKey(account, key string) (string, error) {
+	aead, err := sessionCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(key), []byte(strings.ToLower(account)))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSessionKey(account, encrypted string) (string, error) {
+	aead, err := sessionCipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("invalid encrypted session key")
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, sealed, []byte(strings.ToLower(account)))
+	if err != nil {
+		return "", errors.New("can't decrypt session key; was the API secret changed?")
+	}
+	return string(key), nil
+}
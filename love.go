@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Kovensky/go-lastfm"
+)
+
+func init() {
+	trackArg := ArgSpec{Name: "$artist - $track", Optional: true, Help: "the track; defaults to the one you're playing"}
+
+	RegisterCommand(&Command{
+		Name:        "love",
+		Help:        "Loves the track you're playing, or $artist - $track, on your last.fm account. Needs an account linked with link.",
+		Args:        []ArgSpec{trackArg},
+		UsesSession: true,
+		Handler: func(n *Network, req *Request) {
+			n.setLoved(req, true)
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "unlove",
+		Help:        "Unloves the track you're playing, or $artist - $track, on your last.fm account. Needs an account linked with link.",
+		Args:        []ArgSpec{trackArg},
+		UsesSession: true,
+		Handler: func(n *Network, req *Request) {
+			n.setLoved(req, false)
+		},
+	})
+}
+
+// Loves or unloves a track with the session linked to the services account of
+// the nick that sent the request.
+func (n *Network) setLoved(req *Request, love bool) {
+	ctx := req.Context()
+	session, err := n.sessions.Get(req.Account)
+	if err != nil {
+		n.Println("Error reading session of", req.Nick+":", err)
+		n.reply(req.Target, fmt.Sprintf("%s: your linked account can't be used; use %slink again", req.Nick, req.Settings.Prefix))
+		return
+	}
+	if session == nil {
+		n.reply(req.Target, fmt.Sprintf("%s: link your last.fm account first, with %slink in a private message", req.Nick, req.Settings.Prefix))
+		return
+	}
+
+	var track lastfm.Track
+	if req.Text != "" {
+		parts := strings.SplitN(req.Text, " - ", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			n.reply(req.Target, fmt.Sprintf("%s: usage: %s%s %s", req.Nick, req.Settings.Prefix, req.Command.Name, req.Command.Usage()))
+			return
+		}
+		track = lastfm.Track{Artist: lastfm.Artist{Name: strings.TrimSpace(parts[0])}, Name: strings.TrimSpace(parts[1])}
+	} else {
+		recent, err := lfm.GetRecentTracks(ctx, session.User, 1)
+		if err != nil {
+			r := fmt.Sprintf("[%s] %v", req.Nick, err)
+			n.Println(r)
+			n.reply(req.Target, r)
+			return
+		}
+		if recent.NowPlaying == nil {
+			n.reply(req.Target, fmt.Sprintf("%s: you're not playing anything; give the $artist - $track instead", req.Nick))
+			return
+		}
+		track = *recent.NowPlaying
+	}
+
+	update, done := lfm.UnloveTrack, "unloved"
+	if love {
+		update, done = lfm.LoveTrack, "loved"
+	}
+	n.Println(req.Nick, "as", session.User, "is setting", track.Artist.Name, "-", track.Name, "as", done)
+	if err = update(ctx, session, track); err != nil {
+		r := fmt.Sprintf("[%s] %v", req.Nick, err)
+		if errors.Is(err, lastfm.ErrInvalidSessionKey) {
+			r = fmt.Sprintf("%s: last.fm no longer accepts your linked account; use %slink again", req.Nick, req.Settings.Prefix)
+		}
+		n.Println(r)
+		n.reply(req.Target, r)
+		return
+	}
+
+	r := fmt.Sprintf("[%s] %s %s - %s", req.Nick, done, track.Artist.Name, track.Name)
+	if love {
+		r += " <3"
+	}
+	n.Println("Reply:", r)
+	n.reply(req.Target, r)
+	saveCache()
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+// Settings for a single IRC network. When read from the -config file,
+// missing settings default to the values of the matching command line flags.
+type NetworkConfig struct {
+	Name             string          `json:"name"`              // Used in logs; defaults to the server
+	Server           string          `json:"server"`            // Same as -server
+	SSL              bool            `json:"ssl"`               // Same as -ssl
+	SSLCA            string          `json:"ssl_ca"`            // Same as -ssl-ca
+	SSLFingerprints  []string        `json:"ssl_fingerprints"`  // Same as -ssl-fingerprints
+	SSLCert          string          `json:"ssl_cert"`          // Same as -ssl-cert
+	SSLKey           string          `json:"ssl_key"`           // Same as -ssl-key
+	SSLInsecure      bool            `json:"ssl_insecure"`      // Same as -ssl-insecure
+	Password         string          `json:"password"`          // Same as -password
+	Nick             string          `json:"nick"`              // Same as -nick
+	NickServPassword string          `json:"nickserv_password"` // Same as -nickserv-password
+	SASL             string          `json:"sasl"`              // Same as -sasl
+	SASLUser         string          `json:"sasl_user"`         // Same as -sasl-user
+	Channels         []ChannelConfig `json:"channels"`          // Same as -channels
+	RequireAuth      bool            `json:"require_auth"`      // Same as -require-auth
+	SaveNicks        bool            `json:"save_nicks"`        // Same as -save-nicks
+	NickFile         string          `json:"nick_file"`         // Same as -nick-file
+	NPFormat         string          `json:"np_format"`         // Overrides the global np_format
+	NPFormatFile     string          `json:"np_format_file"`    // Where the npformat command saves templates; defaults to {{server}}.npformats.json
+	SessionFile      string          `json:"session_file"`      // Where the sessions of linked accounts are saved; defaults to {{server}}.sessions.json
+}
+
+// Builds a NetworkConfig from the command line flags.
+func flagNetworkConfig() NetworkConfig {
+	channels := []ChannelConfig{}
+	for _, ch := range strings.Split(*channelList, ",") {
+		if ch = strings.TrimSpace(ch); ch != "" {
+			channels = append(channels, ChannelConfig{Name: ch})
+		}
+	}
+	fingerprints := []string{}
+	for _, fp := range strings.Split(*sslFingerprints, ",") {
+		if fp = strings.TrimSpace(fp); fp != "" {
+			fingerprints = append(fingerprints, fp)
+		}
+	}
+	return NetworkConfig{
+		Server:           *server,
+		SSL:              *useSSL,
+		SSLCA:            *sslCA,
+		SSLFingerprints:  fingerprints,
+		SSLCert:          *sslCert,
+		SSLKey:           *sslKey,
+		SSLInsecure:      *sslInsecure,
+		Password:         *password,
+		Nick:             *botNick,
+		NickServPassword: *nickPass,
+		SASL:             *saslMechanism,
+		SASLUser:         *saslUser,
+		Channels:         channels,
+		RequireAuth:      *requireAuth,
+		SaveNicks:        *saveNicks,
+		NickFile:         *nickFile,
+	}
+}
+
+// Fills in defaults and checks that the required settings are present.
+func (cfg *NetworkConfig) validate() error {
+	if cfg.Server == "" {
+		return fmt.Errorf("no server to connect to")
+	}
+	if cfg.Name == "" {
+		cfg.Name = cfg.Server
+	}
+	if len(cfg.Channels) == 0 {
+		return fmt.Errorf("%s: no channels to join", cfg.Name)
+	}
+	for _, ch := range cfg.Channels {
+		if !isChannel(ch.Name) || strings.ContainsAny(ch.Name, " ,") {
+			return fmt.Errorf("%s: invalid channel name %q", cfg.Name, ch.Name)
+		}
+		if strings.ContainsAny(ch.Prefix, " \t") {
+			return fmt.Errorf("%s: %s: invalid command prefix %q", cfg.Name, ch.Name, ch.Prefix)
+		}
+		where := cfg.Name + ": " + ch.Name
+		if err := checkChannelSettings(where, ch.ReplyMode, ch.Verbosity, ch.NPFormat, ch.Allow, ch.Deny); err != nil {
+			return err
+		}
+	}
+	if err := checkChannelSettings(cfg.Name, "", "", cfg.NPFormat, nil, nil); err != nil {
+		return err
+	}
+	if cfg.Nick == "" {
+		return fmt.Errorf("%s: no nick to use", cfg.Name)
+	}
+	if _, err := cfg.tlsConfig(); err != nil {
+		return fmt.Errorf("%s: %v", cfg.Name, err)
+	}
+	switch cfg.SASL {
+	case SASLAuto, SASLNone:
+	case SASLExternal:
+		if cfg.SSLCert == "" || !cfg.SSL {
+			return fmt.Errorf("%s: SASL EXTERNAL needs ssl and an ssl_cert", cfg.Name)
+		}
+	case SASLPlain:
+		if cfg.NickServPassword == "" {
+			return fmt.Errorf("%s: SASL PLAIN needs a nickserv_password", cfg.Name)
+		}
+	default:
+		return fmt.Errorf("%s: invalid SASL mechanism %q", cfg.Name, cfg.SASL)
+	}
+	if cfg.NickFile == "" {
+		cfg.NickFile = cfg.Server + ".nicks.json"
+	}
+	if cfg.NPFormatFile == "" {
+		cfg.NPFormatFile = cfg.Server + ".npformats.json"
+	}
+	if cfg.SessionFile == "" {
+		cfg.SessionFile = cfg.Server + ".sessions.json"
+	}
+	return nil
+}
+
+// The state of the bot on a single IRC network. Everything but the
+// Last.fm client is kept separately for each network.
+type Network struct {
+	*log.Logger
+
+	config    atomic.Value // *NetworkConfig
+	irc       *client.Conn
+	nickMap   *NickMap
+	npFormats *NPFormats
+	sessions  *Sessions
+
+	isIdentifiedChan  map[string]chan string // By lowercased nick
+	isIdentifiedCache map[string]string      // The services accounts from WHOIS, by lowercased nick
+	isIdentifiedMutex sync.Mutex
+
+	// Pending What's Playing requests, by lowercased channel; used by both
+	// the command and the WHO reply handler
+	whoChannel map[string]chan bool
+	whoResult  map[string][]string
+	whoMutex   sync.Mutex
+
+	sess         *session
+	sessionMutex sync.Mutex
+
+	quitting atomic.Bool // Set by Quit, from another goroutine
+	quit     chan bool
+}
+
+func NewNetwork(cfg NetworkConfig) *Network {
+	n := &Network{
+		Logger:            log.New(os.Stderr, "["+cfg.Name+"] ", log.LstdFlags),
+		isIdentifiedChan:  make(map[string]chan string),
+		isIdentifiedCache: make(map[string]string),
+		whoChannel:        make(map[string]chan bool),
+		whoResult:         make(map[string][]string),
+		quit:              make(chan bool, 1),
+		sess:              newSession(),
+	}
+	n.config.Store(&cfg)
+	n.nickMap = NewNickMap(n)
+	n.npFormats = NewNPFormats(n)
+	n.sessions = NewSessions(n)
+
+	ircConfig := client.NewConfig(cfg.Nick)
+	ircConfig.Version = "github.com/Kovensky/go-lastfm-bot"
+	ircConfig.Flood = false
+
+	n.irc = client.Client(ircConfig)
+	n.addHandlers()
+	return n
+}
+
+// Sets the SSL settings of the connection from cfg. Only called right before
+// connecting, from the goroutine that connects, as goirc reads them without
+// a lock.
+func (n *Network) setTLSConfig(ircConfig *client.Config, cfg *NetworkConfig) {
+	ircConfig.SSL = cfg.SSL
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		// Already checked by validate, unless the files changed since
+		n.Println("Error setting up SSL, connections will fail:", err)
+		tlsConfig = &tls.Config{
+			VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error { return err },
+		}
+	}
+	if cfg.SSL && cfg.SSLInsecure {
+		n.Println("Warning: not verifying the server's certificate")
+	}
+	ircConfig.SSLConfig = tlsConfig
+}
+
+// Returns the network's current settings. They may be replaced at any time
+// by a reload, so callers should not hold on to them.
+func (n *Network) Config() *NetworkConfig {
+	return n.config.Load().(*NetworkConfig)
+}
+
+// Applies new settings to a running network. Channels are joined or parted
+// and the nick is changed right away; server settings are used the next time
+// the bot connects.
+func (n *Network) Reconfigure(cfg NetworkConfig) {
+	old := n.Config()
+	n.config.Store(&cfg)
+
+	oldChannels := make(map[string]bool)
+	for _, ch := range old.Channels {
+		oldChannels[strings.ToLower(ch.Name)] = true
+	}
+	newChannels := make(map[string]bool)
+	for _, ch := range cfg.Channels {
+		newChannels[strings.ToLower(ch.Name)] = true
+		if !oldChannels[strings.ToLower(ch.Name)] && n.irc.Connected() {
+			n.Println("Joining", ch.Name)
+			n.join(ch)
+		}
+	}
+	for _, ch := range old.Channels {
+		if !newChannels[strings.ToLower(ch.Name)] && n.irc.Connected() {
+			n.Println("Parting", ch.Name)
+			n.irc.Part(ch.Name)
+		}
+	}
+
+	if cfg.Nick != old.Nick && n.irc.Connected() {
+		n.Println("Changing nick to", cfg.Nick)
+		n.irc.Nick(cfg.Nick)
+	}
+	if cfg.Server != old.Server || cfg.SSL != old.SSL || cfg.Password != old.Password {
+		n.Println("Server settings changed; they will be used when reconnecting")
+	}
+	if cfg.NickFile != old.NickFile || cfg.NPFormatFile != old.NPFormatFile || cfg.SessionFile != old.SessionFile ||
+		cfg.SaveNicks != old.SaveNicks {
+		n.Println("Changing the nick map, np format or session files requires a restart")
+	}
+}
+
+func (n *Network) addHandlers() {
+	n.addNickHandlers()
+	n.addWhoHandlers()
+
+	n.addCapHandlers()
+	n.addAccountHandlers()
+
+	n.irc.HandleFunc(client.CONNECTED, n.onConnected)
+	n.irc.HandleFunc("NOTICE", func(irc *client.Conn, line *client.Line) {
+		if strings.ToLower(line.Nick) == "nickserv" {
+			n.Println("NickServ:", line.Args[1])
+			switch {
+			case strings.Contains(strings.ToLower(line.Args[1]), "ghost"):
+				n.Println("Ghost command successful")
+				n.Println("Changing nick to", n.Config().Nick)
+				irc.Nick(n.Config().Nick)
+			case strings.Contains(line.Args[1], "identified"),
+				strings.Contains(line.Args[1], "recognized"):
+				if n.joinChannelsOnce(n.session()) {
+					n.Println("Successfully identified with NickServ; joined channels")
+				}
+			}
+		}
+	})
+	n.irc.HandleFunc("QUIT", func(irc *client.Conn, line *client.Line) {
+		if line.Nick == n.Config().Nick {
+			n.Println("Nick", n.Config().Nick, "now available, changing to it")
+			irc.Nick(n.Config().Nick)
+		}
+	})
+	n.irc.HandleFunc("NICK", func(irc *client.Conn, line *client.Line) {
+		if line.Args[len(line.Args)-1] == irc.Me().Nick {
+			n.Println("Nick successfully changed to", irc.Me().Nick)
+			s := n.session()
+			s.Lock()
+			authenticated := s.authenticated
+			s.Unlock()
+			if pass := n.Config().NickServPassword; pass != "" && !authenticated {
+				n.Println("Identifying with NickServ")
+				irc.Privmsg("NickServ", fmt.Sprintf("IDENTIFY %s", pass))
+			}
+		}
+	})
+	n.irc.HandleFunc("332", func(irc *client.Conn, line *client.Line) {
+		n.Println("Joined", line.Args[1])
+	})
+	n.irc.HandleFunc("INVITE", n.onInvite)
+	n.irc.HandleFunc("PRIVMSG", n.onPrivmsg)
+
+	n.irc.HandleFunc(client.DISCONNECTED, func(irc *client.Conn, line *client.Line) {
+		if n.quitting.Load() {
+			select {
+			case n.quit <- true:
+			default:
+			}
+			return
+		}
+		n.resetIdentifiedCache()
+		n.Println("Disconnected; waiting 10 seconds then reconnecting...")
+		saveCacheNow()
+		go n.reconnect()
+	})
+}
+
+func (n *Network) reconnect() {
+	time.Sleep(10 * time.Second)
+	errorCount := 0
+	for !n.irc.Connected() && !n.quitting.Load() {
+		n.Println("Reconnecting...")
+		err := n.connectTo(n.Config())
+		if err != nil {
+			n.logConnectError("Error reconnecting", err)
+			// limited exponential backoff (10, 12, 14, 18, 26, 42, 74)
+			retryDuration := 10 + time.Duration(math.Pow(2, float64(errorCount)))*time.Second
+			if errorCount < 6 {
+				errorCount += 1
+			}
+			n.Println("Retrying in", retryDuration)
+			time.Sleep(retryDuration)
+		}
+	}
+}
+
+func (n *Network) join(ch ChannelConfig) {
+	if ch.Key != "" {
+		n.irc.Join(ch.Name + " " + ch.Key)
+	} else {
+		n.irc.Join(ch.Name)
+	}
+}
+
+func (n *Network) joinChannels() {
+	for _, ch := range n.Config().Channels {
+		n.join(ch)
+	}
+}
+
+func (n *Network) Connect() {
+	cfg := n.Config()
+	if cfg.SSL {
+		n.Println("Using SSL")
+	}
+	n.Println("Connecting to", cfg.Server)
+	if err := n.connectTo(cfg); err != nil {
+		n.logConnectError("Error connecting", err)
+		go n.reconnect()
+	}
+}
+
+// Connects with the given settings. The certificates are loaded again every
+// time, as they may have been renewed.
+func (n *Network) connectTo(cfg *NetworkConfig) error {
+	n.setTLSConfig(n.irc.Config(), cfg)
+	return n.irc.ConnectTo(cfg.Server, cfg.Password)
+}
+
+// Sends a QUIT to the server, and waits until the connection is closed or
+// until the timeout passes.
+func (n *Network) Quit(timeout time.Duration) {
+	n.quitting.Store(true)
+	if !n.irc.Connected() {
+		return
+	}
+	n.Println("Disconnecting")
+	n.irc.Quit("Exiting")
+	select {
+	case <-n.quit: // wait until the QUIT is sent to server
+	case <-time.After(timeout):
+		n.Println("Server didn't close the connection in", timeout)
+	}
+}
+
+func (n *Network) onInvite(irc *client.Conn, line *client.Line) {
+	who, channel := line.Args[0], line.Args[1]
+	n.Println(line.Nick, "invited bot to", channel)
+	if who == irc.Me().Nick {
+		// some IRCds only allow operators to INVITE, and on registered channels normally only identified users are operators
+		// check anyway, since there are some corner cases where that doesn't happen
+		if n.checkIdentified(line.Nick) {
+			n.Println("Accepting invite to", channel)
+			irc.Join(channel)
+		} else {
+			irc.Notice(line.Nick, "you must be identified to invite")
+			n.Println("Ignoring invite, user is not identified")
+		}
+	}
+}
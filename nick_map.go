@@ -1,10 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"sort"
 	"strings"
@@ -29,30 +29,32 @@ type NickMap struct {
 	nickMap    map[string]string
 	reverseMap map[string][]string
 	beingSaved bool
+	dirty      bool // Whether there are changes that were not saved
+	network    *Network
 	sync.Mutex
 }
 
-func NewNickMap() *NickMap {
+func NewNickMap(n *Network) *NickMap {
 	return &NickMap{
 		nickMap:    make(map[string]string),
-		reverseMap: make(map[string][]string)}
+		reverseMap: make(map[string][]string),
+		network:    n}
 }
 
-func loadNickMap() {
-	if *saveNicks {
-		path := *nickFile
-		if path == "" {
-			path = *server + ".nicks.json"
-		}
-		log.Println("Watching", path)
-		decodeNickMap(path)
+// Loads the map from the network's NickFile and starts watching it for changes.
+func (m *NickMap) Load() {
+	n := m.network
+	if cfg := n.Config(); cfg.SaveNicks {
+		path := cfg.NickFile
+		n.Println("Watching", path)
+		m.decode(path)
 		watcher, err := fsnotify.NewWatcher()
 		if err != nil {
-			log.Fatalln(err)
+			n.Fatalln(err)
 		}
 		err = watcher.Watch(path)
 		if err != nil {
-			log.Println(err)
+			n.Println(err)
 		}
 		go func() {
 			var timer <-chan time.Time
@@ -61,13 +63,13 @@ func loadNickMap() {
 				case <-watcher.Event:
 					timer = time.After(time.Second)
 				case err := <-watcher.Error:
-					log.Println(err)
+					n.Println(err)
 				case <-timer:
-					if !nickMap.beingSaved {
-						log.Println("Nick persistence file changed, reloading...")
-						decodeNickMap(path)
+					if !m.beingSaved {
+						n.Println("Nick persistence file changed, reloading...")
+						m.decode(path)
 					}
-					nickMap.beingSaved = false
+					m.beingSaved = false
 					timer = nil
 				}
 			}
@@ -75,40 +77,41 @@ func loadNickMap() {
 	}
 }
 
-func decodeNickMap(path string) {
+func (m *NickMap) decode(path string) {
 	fh, err := os.Open(path)
 	if err != nil {
-		log.Println("Error opening nick persistence file:", err)
+		m.network.Println("Error opening nick persistence file:", err)
 	} else {
-		nickMap.Lock()
+		m.Lock()
 		j := json.NewDecoder(fh)
-		err = j.Decode(nickMap)
-		nickMap.Unlock()
+		err = j.Decode(m)
+		m.dirty = false
+		m.Unlock()
 		if err != nil {
-			log.Println("Error reading nick-user map:", err)
+			m.network.Println("Error reading nick-user map:", err)
 		}
 		fh.Close()
 	}
 }
 
-func saveNickMap() {
-	if *saveNicks {
-		nickMap.beingSaved = true
-		path := *nickFile
-		if path == "" {
-			path = *server + ".nicks.json"
-		}
-		fh, err := os.Create(path)
+// Writes the map to the network's NickFile. Must be called with the lock held.
+func (m *NickMap) save() {
+	n := m.network
+	if cfg := n.Config(); cfg.SaveNicks {
+		m.beingSaved = true
+		fh, err := os.Create(cfg.NickFile)
 		if err != nil {
-			log.Println("Error creating nick persistence file:", err)
+			n.Println("Error creating nick persistence file:", err)
 		} else {
-			b, err := json.MarshalIndent(nickMap, "", "\t")
+			b, err := json.MarshalIndent(m, "", "\t")
 			if err != nil {
-				log.Println("Error marshaling nick-user map:", err)
+				n.Println("Error marshaling nick-user map:", err)
 			}
 			_, err = fh.Write(b)
 			if err != nil {
-				log.Println("Error writing persistence file:", err)
+				n.Println("Error writing persistence file:", err)
+			} else {
+				m.dirty = false
 			}
 
 			fh.Close()
@@ -116,15 +119,67 @@ func saveNickMap() {
 	}
 }
 
-func addNickHandlers(irc *client.Conn) {
-	if !flag.Parsed() {
-		flag.Parse()
+// Saves any changes that were not saved yet.
+func (m *NickMap) Flush() {
+	m.Lock()
+	defer m.Unlock()
+	if m.dirty {
+		m.save()
 	}
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:        "ignore",
+		Help:        "Makes the bot ignore you for most commands. Use setuser or deluser to be unignored.",
+		RequireAuth: true,
+		Handler: func(n *Network, req *Request) {
+			n.nickMap.IgnoreNick(n.irc, req.Target, req.Nick)
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "setuser",
+		Help:        "Associates your nick with the given last.fm $username.",
+		Args:        []ArgSpec{{Name: "$username", Help: "your last.fm username"}},
+		RequireAuth: true,
+		Handler: func(n *Network, req *Request) {
+			n.nickMap.AddNick(req.Context(), n.irc, req.Target, req.Nick, req.Args[0])
+		},
+	})
+	RegisterCommand(&Command{
+		Name:        "deluser",
+		Help:        "Removes your nick's association, if any.",
+		RequireAuth: true,
+		Handler: func(n *Network, req *Request) {
+			n.nickMap.DelNick(n.irc, req.Target, req.Nick)
+		},
+	})
+	RegisterCommand(&Command{
+		Name: "whois",
+		Help: "Shows your associated last.fm username, or the username associated with $nick.",
+		Args: []ArgSpec{{Name: "$nick", Optional: true}},
+		Handler: func(n *Network, req *Request) {
+			who := req.Nick
+			if len(req.Args) > 0 {
+				who = req.Args[0]
+			}
+			n.nickMap.QueryNick(n.irc, req.Target, req.Nick, who)
+		},
+	})
+	RegisterCommand(&Command{
+		Name: "aka",
+		Help: "Shows the nicks that have been associated with $username.",
+		Args: []ArgSpec{{Name: "$username"}},
+		Handler: func(n *Network, req *Request) {
+			n.nickMap.ListAllNicks(n.irc, req.Target, req.Nick, req.Args[0])
+		},
+	})
+}
 
-	irc.HandleFunc("QUIT", dropIdentifiedCache)
-	irc.HandleFunc("307", isIdentified)
-	irc.HandleFunc("330", isIdentified)
-	irc.HandleFunc("318", isIdentified)
+func (n *Network) addNickHandlers() {
+	n.irc.HandleFunc("307", n.isIdentified)
+	n.irc.HandleFunc("330", n.isIdentified)
+	n.irc.HandleFunc("318", n.isIdentified)
 }
 
 func (m *NickMap) MarshalJSON() (j []byte, err error) {
@@ -144,8 +199,8 @@ func (m *NickMap) UnmarshalJSON(j []byte) (err error) {
 	if err != nil {
 		return
 	}
-	nickMap.nickMap = make(map[string]string)
-	nickMap.reverseMap = make(map[string][]string)
+	m.nickMap = make(map[string]string)
+	m.reverseMap = make(map[string][]string)
 	for user, nicks := range data {
 		for _, nick := range nicks {
 			m.setUser(nick, user)
@@ -161,79 +216,67 @@ func (err *NickMapError) Error() string {
 }
 
 func (m *NickMap) IgnoreNick(irc *client.Conn, target, nick string) (err error) {
-	log.Println("Adding", nick, "to ignored list")
-	identified := checkIdentified(irc, nick)
-	if !identified {
-		irc.Privmsg(target, fmt.Sprintf("%s: you must be identified with NickServ to use this command", nick))
-		log.Println("Nick", nick, "is not identified, and identity verification is enabled")
-		e := NickMapError("nick is not identified")
-		return &e
-	}
+	m.network.Println("Adding", nick, "to ignored list")
 	m.Lock()
 	m.setUser(nick, "")
-	saveNickMap()
+	m.save()
 	m.Unlock()
 
-	r := fmt.Sprintf("[%s] is now ignored by last.fm commands; use %sdeluser to be unignored", nick, *cmdPrefix)
-	log.Println(r)
-	irc.Privmsg(target, r)
+	r := fmt.Sprintf("[%s] is now ignored by last.fm commands; use %sdeluser to be unignored", nick, getConfig().CmdPrefix)
+	m.network.Println(r)
+	m.network.reply(target, r)
 	return nil
 }
 
-func (m *NickMap) AddNick(irc *client.Conn, target, nick, user string) (err error) {
-	identified := checkIdentified(irc, nick)
-	if !identified {
-		irc.Privmsg(target, fmt.Sprintf("%s: you must be identified with NickServ to use this command", nick))
-		e := NickMapError("nick is not identified")
-		return &e
-	}
-	log.Println("Checking whether", user, "is a valid Last.fm user for associating with", nick)
+func (m *NickMap) AddNick(ctx context.Context, irc *client.Conn, target, nick, user string) (err error) {
+	m.network.Println("Checking whether", user, "is a valid Last.fm user for associating with", nick)
 	// Smallest query we can do (we're only interested in errors)
-	_, err = lfm.GetUserTopArtists(user, lastfm.OneWeek, 1)
+	_, err = lfm.GetUserTopArtists(ctx, user, lastfm.OneWeek, 1)
 	if err != nil {
 		extra := ""
-		lfmerr, ok := err.(*lastfm.LastFMError)
-		// lfmerr.Code is unreliable; a lot of things may be code 6...
-		if ok && lfmerr.Error() == "No user with that name" {
-			// plus their error messages are misleading...
+		if lastfm.IsNotFound(err) {
+			// last.fm's error messages are misleading...
 			extra = ", or user never scrobbled anything"
 		}
 
 		r := fmt.Sprintf("[%s] %v%s", nick, err, extra)
-		log.Println(r)
-		irc.Privmsg(target, r)
+		m.network.Println(r)
+		m.network.reply(target, r)
 		return err
 	}
 	m.Lock()
 	m.setUser(nick, user)
-	saveNickMap()
+	m.save()
 	m.Unlock()
 
 	r := fmt.Sprintf("[%s] is now associated with last.fm user %s", nick, user)
-	log.Println(r)
-	irc.Privmsg(target, r)
+	m.network.Println(r)
+	m.network.reply(target, r)
 	return nil
 }
 
+// Associates the nick with the user of a session it linked.
+func (m *NickMap) Link(nick, user string) {
+	m.network.Println("Associating", nick, "with linked last.fm user", user)
+	m.Lock()
+	m.setUser(nick, user)
+	m.save()
+	m.Unlock()
+}
+
 func (m *NickMap) DelNick(irc *client.Conn, target, nick string) (err error) {
 	if user, ok := m.GetUser(nick); !ok {
-		irc.Privmsg(target, fmt.Sprintf("%s: you're not associated with an username", nick))
+		m.network.reply(target, fmt.Sprintf("%s: you're not associated with an username", nick))
 		e := NickMapError("nick isn't associated")
 		return &e
 	} else {
-		identified := checkIdentified(irc, nick)
-		if !identified {
-			irc.Privmsg(target, fmt.Sprintf("%s: you must be identified with NickServ to use this command", nick))
-			log.Println("Nick", nick, "is not identified, and identity verification is enabled")
-			e := NickMapError("nick is not identified")
-			return &e
-		}
 		m.Lock()
 		m.delUser(nick)
+		m.save()
 		m.Unlock()
 		r := fmt.Sprintf("[%s] is no longer associated with last.fm user %s", nick, user)
-		log.Println(r)
-		irc.Privmsg(target, r)
+		m.network.Println(r)
+		m.network.reply(target, r)
 		return nil
 	}
 }
@@ -265,7 +308,7 @@ func (m *NickMap) QueryNick(irc *client.Conn, target, asker, nick string) {
 		}
 		r += fmt.Sprintf("didn't associate an username")
 	}
-	irc.Privmsg(target, r)
+	m.network.reply(target, r)
 	return
 }
 
@@ -284,8 +327,8 @@ func (m *NickMap) ListAllNicks(irc *client.Conn, target, asker, user string) {
 		r = fmt.Sprintf("%s: %s's known IRC nick%s %s",
 			asker, user, plural, strings.Join(sort.StringSlice(nicks), ", "))
 	}
-	log.Println(r)
-	irc.Privmsg(target, r)
+	m.network.Println(r)
+	m.network.reply(target, r)
 	return
 }
 
@@ -295,16 +338,17 @@ func (m *NickMap) GetUser(nick string) (user string, ok bool) {
 	user, ok = m.nickMap[strings.ToLower(nick)]
 	if ok {
 		if user == "" {
-			log.Println("Nick", nick, "requested to be ignored")
+			m.network.Println("Nick", nick, "requested to be ignored")
 			return user, ok
 		}
-		log.Println("Nick", nick, "is associated with", user)
+		m.network.Println("Nick", nick, "is associated with", user)
 		return user, ok
 	}
 	return nick, ok
 }
 
 func (m *NickMap) setUser(nick, user string) {
+	m.dirty = true
 	if _, ok := m.nickMap[strings.ToLower(nick)]; ok {
 		m.delUser(nick)
 	}
@@ -319,6 +363,7 @@ func (m *NickMap) setUser(nick, user string) {
 }
 
 func (m *NickMap) delUser(nick string) {
+	m.dirty = true
 	lcuser := ""
 	if user, ok := m.nickMap[strings.ToLower(nick)]; ok {
 		lcuser = strings.ToLower(user)
@@ -336,74 +381,116 @@ func (m *NickMap) delUser(nick string) {
 	}
 }
 
-var (
-	isIdentifiedChan  = make(map[string]chan bool)
-	isIdentifiedCache = make(map[string]bool)
-)
-
-func resetIdentifiedCache() {
-	isIdentifiedCache = make(map[string]bool)
+func (n *Network) resetIdentifiedCache() {
+	n.isIdentifiedMutex.Lock()
+	defer n.isIdentifiedMutex.Unlock()
+	n.isIdentifiedCache = make(map[string]string)
 	// We also reset the channels, in case there's any verification pending
-	for _, c := range isIdentifiedChan {
+	for _, c := range n.isIdentifiedChan {
 		close(c)
 	}
-	isIdentifiedChan = make(map[string]chan bool)
+	n.isIdentifiedChan = make(map[string]chan string)
 	return
 }
 
-func dropIdentifiedCache(irc *client.Conn, line *client.Line) {
-	delete(isIdentifiedCache, line.Nick)
-	return
+// Checks whether the nick is identified with services, if the network
+// requires it.
+func (n *Network) checkIdentified(nick string) bool {
+	if !n.Config().RequireAuth {
+		return true
+	}
+	return n.verifyIdentified(nick)
 }
 
-func checkIdentified(irc *client.Conn, nick string) bool {
-	if !*requireAuth {
-		return true
+// Checks whether the nick is identified with services, whatever the network
+// settings.
+func (n *Network) verifyIdentified(nick string) bool {
+	return n.identifiedAccount(nick) != ""
+}
+
+// Returns the services account the nick is identified to, or "" if it isn't
+// identified. The accounts tracked from the IRCv3 capabilities are used if
+// possible; otherwise, a WHOIS is sent.
+func (n *Network) identifiedAccount(nick string) string {
+	if account, ok := n.account(nick); ok {
+		if account != "" {
+			n.Println("Nick", nick, "is logged in as", account)
+		} else {
+			n.Println("Nick", nick, "is not logged in")
+		}
+		return account
 	}
+
+	// Keyed like the accounts, as servers may echo the nick in another case
+	key := strings.ToLower(nick)
+	n.isIdentifiedMutex.Lock()
 	// We don't cache identification failures since the user can always identify later
-	if isIdentifiedCache[nick] {
-		return true
+	if account := n.isIdentifiedCache[key]; account != "" {
+		n.isIdentifiedMutex.Unlock()
+		return account
 	}
 
-	log.Println("Checking whether", nick, "is identified")
+	n.Println("Checking whether", nick, "is identified")
+
+	c := make(chan string, 2) // for both a 307 and a 330
+	n.isIdentifiedChan[key] = c
+	n.isIdentifiedMutex.Unlock()
 
-	isIdentifiedChan[nick] = make(chan bool, 1)
 	timeout := time.After(10 * time.Second)
-	go irc.Whois(nick)
+	go n.irc.Whois(nick)
 
-	r := false
-	for is, ok := false, true; ok; {
+	r := ""
+	for account, ok := "", true; ok; {
 		select {
-		case is, ok = <-isIdentifiedChan[nick]:
-			if is {
-				r = true
+		case account, ok = <-c:
+			// Any account named by a 330 wins over the nick from a 307
+			if account != "" && (r == "" || !strings.EqualFold(account, nick)) {
+				r = account
 			}
 		case <-timeout:
-			log.Println("Timeout checking for whether", nick, "is identified")
-			return false
+			n.Println("Timeout checking for whether", nick, "is identified")
+			return ""
 		}
 	}
-	delete(isIdentifiedChan, nick)
 
-	log.Println("Is nick", nick, "identified:", r)
-	if r {
-		isIdentifiedCache[nick] = true
+	if r != "" {
+		n.Println("Nick", nick, "is identified as", r)
+	} else {
+		n.Println("Nick", nick, "is not identified")
+	}
+	n.isIdentifiedMutex.Lock()
+	if n.isIdentifiedChan[key] == c {
+		delete(n.isIdentifiedChan, key)
 	}
+	if r != "" {
+		n.isIdentifiedCache[key] = r
+	}
+	n.isIdentifiedMutex.Unlock()
 
 	return r
 }
 
-var isIdentifiedMutex sync.Mutex
-
-func isIdentified(irc *client.Conn, line *client.Line) {
-	nick := line.Args[1]
-	isIdentifiedMutex.Lock()
-	switch line.Cmd {
-	case "307", "330": // identified; 330 is the freenode version
-		isIdentifiedChan[nick] <- true
-	case "318": // end of response
-		close(isIdentifiedChan[nick])
+func (n *Network) isIdentified(irc *client.Conn, line *client.Line) {
+	nick := strings.ToLower(line.Args[1])
+	n.isIdentifiedMutex.Lock()
+	if c, ok := n.isIdentifiedChan[nick]; ok {
+		switch line.Cmd {
+		case "307", "330": // identified; 330 is the freenode version
+			// 330 me nick account :is logged in as; 307 doesn't name the
+			// account, which is then taken to be the nick
+			account := line.Args[1]
+			if line.Cmd == "330" && len(line.Args) >= 3 {
+				account = line.Args[2]
+			}
+			select {
+			case c <- account:
+			default:
+			}
+		case "318": // end of response
+			close(c)
+			delete(n.isIdentifiedChan, nick)
+		}
 	}
-	isIdentifiedMutex.Unlock()
+	n.isIdentifiedMutex.Unlock()
 	return
 }
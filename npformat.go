@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Kovensky/go-lastfm"
+)
+
+var npFormat = flag.String("np-format", "", `A text/template for the output of np. If blank, a built-in one that follows -verbosity is used.`)
+
+// Limits on the size of now playing templates and of their output.
+const (
+	maxNPFormatLength = 400
+	maxNPOutputLength = 400
+)
+
+// The built-in now playing template.
+const defaultNPFormat = `[{{.Nick}}] np: {{.Track.Artist.Name}} - {{.Track.Name}}` +
+	`{{if eq .Verbosity "long"}}{{with .Track.Album}}{{with .Name}} (from {{.}}){{end}}{{end}}{{end}}` +
+	`{{if ne .Verbosity "short"}}` +
+	` [{{if .Track.UserLoved}}<3 - {{end}}{{if gt .Track.UserPlaycount 0}}playcount {{.Track.UserPlaycount}}x{{else}}first listen{{end}}]` +
+	` ({{join .Tags ", "}}){{with .Track.Duration}} [{{.}}]{{end}}{{end}}`
+
+// The data now playing templates are executed with.
+type NowPlaying struct {
+	Nick      string // The nick or username that was asked about
+	User      string // The last.fm username
+	Verbosity string // The verbosity setting of the channel
+
+	Track   *lastfm.TrackInfo
+	TopTags *lastfm.TopTags // The track's top tags, or the artist's if the track has none; may be nil
+	Recent  *lastfm.RecentTracks
+}
+
+// Returns the names of up to 5 of the top tags.
+func (np *NowPlaying) Tags() []string {
+	tags := []string{}
+	for i := 0; np.TopTags != nil && i < 5 && i < len(np.TopTags.Tags); i++ {
+		tags = append(tags, np.TopTags.Tags[i].Name)
+	}
+	return tags
+}
+
+var npFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// Templates are checked against these when validating, so that mistakes such
+// as missing fields, or fields that may be nil, are caught right away instead
+// of when someone uses np.
+var sampleNowPlaying = func() []*NowPlaying {
+	track := lastfm.Track{
+		NowPlaying: true,
+		Artist:     lastfm.Artist{Name: "Artist"},
+		Album:      lastfm.Album{Name: "Album"},
+		Name:       "Track",
+	}
+	return []*NowPlaying{
+		{
+			Nick:      "nick",
+			User:      "user",
+			Verbosity: VerbosityLong,
+			Track: &lastfm.TrackInfo{
+				Name:          "Track",
+				Artist:        lastfm.Artist{Name: "Artist"},
+				Album:         &lastfm.AlbumInfo{Name: "Album", Artist: "Artist"},
+				Duration:      3 * time.Minute,
+				UserPlaycount: 10,
+				UserLoved:     true,
+				TopTags:       []string{"tag"},
+			},
+			TopTags: &lastfm.TopTags{Track: "Track", Tags: []lastfm.Tag{{Name: "tag"}}},
+			Recent:  &lastfm.RecentTracks{User: "user", Total: 1, Tracks: []lastfm.Track{track}, NowPlaying: &track},
+		},
+		{
+			Nick:      "nick",
+			User:      "nick",
+			Verbosity: VerbosityShort,
+			Track: &lastfm.TrackInfo{
+				Name:          "Track",
+				Artist:        lastfm.Artist{Name: "Artist"},
+				Duration:      -1,
+				UserPlaycount: -1,
+			},
+			Recent: &lastfm.RecentTracks{User: "nick", Total: 1, Tracks: []lastfm.Track{track}, NowPlaying: &track},
+		},
+	}
+}()
+
+var errNPOutputTooLong = errors.New("output too long")
+
+// A buffer that refuses to grow past maxNPOutputLength, so that templates
+// can't produce huge replies.
+type npBuffer struct {
+	bytes.Buffer
+}
+
+func (b *npBuffer) Write(p []byte) (int, error) {
+	if room := maxNPOutputLength - b.Len(); len(p) > room {
+		b.Buffer.Write(p[:room])
+		return room, errNPOutputTooLong
+	}
+	return b.Buffer.Write(p)
+}
+
+func parseNPFormat(format string) (*template.Template, error) {
+	if len(format) > maxNPFormatLength {
+		return nil, fmt.Errorf("template is longer than %d characters", maxNPFormatLength)
+	}
+	t, err := template.New("np").Funcs(npFuncs).Parse(format)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Templates()) > 1 {
+		return nil, fmt.Errorf("template definitions are not allowed")
+	}
+	if err = checkNPNode(t.Root, npRootType, false); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+var npRootType = reflect.TypeOf(&NowPlaying{})
+
+// Rejects what could make a template run for long without printing anything,
+// which the output limit doesn't stop: calls to templates, nested ranges, and
+// ranges over anything but a list in the data, such as integers. dot is the
+// type . has at node, or nil if it's not known.
+func checkNPNode(node parse.Node, dot reflect.Type, inRange bool) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, n := range node.Nodes {
+			if err := checkNPNode(n, dot, inRange); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		if err := checkNPNode(node.List, dot, inRange); err != nil {
+			return err
+		}
+		return checkNPNode(node.ElseList, dot, inRange)
+	case *parse.WithNode:
+		if err := checkNPNode(node.List, npPipeType(node.Pipe, dot), inRange); err != nil {
+			return err
+		}
+		return checkNPNode(node.ElseList, dot, inRange)
+	case *parse.RangeNode:
+		if inRange {
+			return fmt.Errorf("nested ranges are not allowed")
+		}
+		t := npPipeType(node.Pipe, dot)
+		for t != nil && t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t == nil || t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return fmt.Errorf("range is only allowed over a list, such as .Tags")
+		}
+		if err := checkNPNode(node.List, t.Elem(), true); err != nil {
+			return err
+		}
+		return checkNPNode(node.ElseList, dot, inRange)
+	case *parse.TemplateNode:
+		return fmt.Errorf("template calls are not allowed")
+	}
+	return nil
+}
+
+// Returns the type of a pipeline that is only a field, such as .Track.Name or
+// $.Tags, or nil for anything else.
+func npPipeType(pipe *parse.PipeNode, dot reflect.Type) reflect.Type {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		if dot != nil {
+			return npFieldType(dot, arg.Ident)
+		}
+	case *parse.VariableNode:
+		if arg.Ident[0] == "$" {
+			return npFieldType(npRootType, arg.Ident[1:])
+		}
+	}
+	return nil
+}
+
+// Follows a chain of field or method names from t, the way templates do.
+// Returns nil if one of them isn't found.
+func npFieldType(t reflect.Type, names []string) reflect.Type {
+	for _, name := range names {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if m, ok := reflect.PointerTo(t).MethodByName(name); ok {
+			if m.Type.NumOut() == 0 {
+				return nil
+			}
+			t = m.Type.Out(0)
+			continue
+		}
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			return nil
+		}
+		t = f.Type
+	}
+	return t
+}
+
+// Checks that the template parses and that it works with the sample data.
+func checkNPFormat(format string) error {
+	t, err := parseNPFormat(format)
+	if err != nil {
+		return err
+	}
+	for _, np := range sampleNowPlaying {
+		if _, err = executeNPFormat(t, np); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs the template, truncating its output to maxNPOutputLength and putting
+// it in a single line.
+func executeNPFormat(t *template.Template, np *NowPlaying) (string, error) {
+	b := &npBuffer{}
+	err := t.Execute(b, np)
+	truncated := err == errNPOutputTooLong
+	if err != nil && !truncated {
+		return "", err
+	}
+	out := b.Bytes()
+	if truncated {
+		// don't leave half of a character behind
+		for len(out) > 0 && !utf8.Valid(out) {
+			out = out[:len(out)-1]
+		}
+	}
+	r := strings.Join(strings.Fields(string(out)), " ")
+	if truncated {
+		r += "..."
+	}
+	return r, nil
+}
+
+// Renders the first of the formats that works, falling back to the built-in
+// one if none does.
+func (n *Network) renderNowPlaying(np *NowPlaying, formats ...string) string {
+	for _, format := range append(formats, defaultNPFormat) {
+		if format == "" {
+			continue
+		}
+		t, err := parseNPFormat(format)
+		if err == nil {
+			var r string
+			if r, err = executeNPFormat(t, np); err == nil {
+				return r
+			}
+		}
+		n.Printf("Error in np format %q: %v\n", format, err)
+	}
+	return ""
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name: "npformat",
+		Help: "Sets the template used when you use np. Without $template, shows your current one; " +
+			`"reset" goes back to the channel's.`,
+		Args: []ArgSpec{{Name: "$template", Optional: true,
+			Help: "a Go text/template, such as {{.Nick}} is listening to {{.Track.Name}} by {{.Track.Artist.Name}}"}},
+		RequireAuth: true,
+		Handler: func(n *Network, req *Request) {
+			switch req.Text {
+			case "":
+				n.npFormats.Show(req.Target, req.Nick)
+			case "reset":
+				n.npFormats.Del(req.Target, req.Nick)
+			default:
+				n.npFormats.Set(req.Target, req.Nick, req.Text)
+			}
+		},
+	})
+}
+
+// The now playing templates set by users with the npformat command, keyed by
+// lowercased nick.
+type NPFormats struct {
+	formats map[string]string
+	network *Network
+	sync.Mutex
+}
+
+func NewNPFormats(n *Network) *NPFormats {
+	return &NPFormats{
+		formats: make(map[string]string),
+		network: n}
+}
+
+// Loads the templates from the network's NPFormatFile.
+func (f *NPFormats) Load() {
+	n := f.network
+	cfg := n.Config()
+	if !cfg.SaveNicks {
+		return
+	}
+	fh, err := os.Open(cfg.NPFormatFile)
+	if err != nil {
+		n.Println("Error opening np format file:", err)
+		return
+	}
+	defer fh.Close()
+	f.Lock()
+	defer f.Unlock()
+	if err = json.NewDecoder(fh).Decode(&f.formats); err != nil {
+		n.Println("Error reading np formats:", err)
+	}
+}
+
+// Writes the templates to the network's NPFormatFile. Must be called with the lock held.
+func (f *NPFormats) save() {
+	n := f.network
+	if cfg := n.Config(); cfg.SaveNicks {
+		b, err := json.MarshalIndent(f.formats, "", "\t")
+		if err != nil {
+			n.Println("Error marshaling np formats:", err)
+			return
+		}
+		if err = os.WriteFile(cfg.NPFormatFile, b, 0644); err != nil {
+			n.Println("Error writing np format file:", err)
+		}
+	}
+}
+
+// Returns the template set by the nick, if any.
+func (f *NPFormats) Get(nick string) string {
+	f.Lock()
+	defer f.Unlock()
+	return f.formats[strings.ToLower(nick)]
+}
+
+func (f *NPFormats) Set(target, nick, format string) {
+	if err := checkNPFormat(format); err != nil {
+		r := fmt.Sprintf("%s: invalid np format: %v", nick, err)
+		f.network.Println(r)
+		f.network.reply(target, r)
+		return
+	}
+	f.Lock()
+	f.formats[strings.ToLower(nick)] = format
+	f.save()
+	f.Unlock()
+
+	r := fmt.Sprintf("[%s] np format set", nick)
+	f.network.Println(r)
+	f.network.reply(target, r)
+}
+
+func (f *NPFormats) Del(target, nick string) {
+	f.Lock()
+	_, ok := f.formats[strings.ToLower(nick)]
+	delete(f.formats, strings.ToLower(nick))
+	if ok {
+		f.save()
+	}
+	f.Unlock()
+
+	if !ok {
+		f.network.reply(target, fmt.Sprintf("%s: you didn't set an np format", nick))
+		return
+	}
+	r := fmt.Sprintf("[%s] np format reset to the default", nick)
+	f.network.Println(r)
+	f.network.reply(target, r)
+}
+
+func (f *NPFormats) Show(target, nick string) {
+	if format := f.Get(nick); format != "" {
+		f.network.reply(target, fmt.Sprintf("%s: your np format is %s", nick, format))
+	} else {
+		f.network.reply(target, fmt.Sprintf("%s: you use the default np format", nick))
+	}
+}
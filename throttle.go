@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A token bucket specification: up to Burst commands can be sent at once,
+// and one more is allowed for every Every that passes.
+type RateLimit struct {
+	Burst int
+	Every time.Duration
+}
+
+// Parses a RateLimit in the "$burst/$interval" format, such as "3/20s".
+// A blank string or "0" disables the limit.
+func (r *RateLimit) Set(s string) error {
+	if s == "" || s == "0" {
+		*r = RateLimit{}
+		return nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid rate limit %q, expected $burst/$interval", s)
+	}
+	burst, err := strconv.Atoi(parts[0])
+	if err != nil || burst < 0 {
+		return fmt.Errorf("invalid burst in rate limit %q", s)
+	}
+	every, err := time.ParseDuration(parts[1])
+	if err != nil || every <= 0 {
+		return fmt.Errorf("invalid interval in rate limit %q", s)
+	}
+	*r = RateLimit{Burst: burst, Every: every}
+	return nil
+}
+
+func (r *RateLimit) String() string {
+	if !r.Enabled() {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%v", r.Burst, r.Every)
+}
+
+func (r *RateLimit) Enabled() bool {
+	return r.Burst > 0 && r.Every > 0
+}
+
+var (
+	nickLimit    = RateLimit{Burst: 4, Every: 15 * time.Second}
+	hostLimit    = RateLimit{Burst: 6, Every: 15 * time.Second}
+	channelLimit = RateLimit{Burst: 10, Every: 5 * time.Second}
+	wpCooldown   = flag.Duration("wp-cooldown", 5*time.Minute, `How long a channel must wait between uses of the wp command.`)
+)
+
+func init() {
+	flag.Var(&nickLimit, "throttle-nick", `Command rate limit for each nick, as "$burst/$interval": $burst commands at once, plus one every $interval. "0" disables it.`)
+	flag.Var(&hostLimit, "throttle-host", `Command rate limit for each user@host, in the same format as -throttle-nick.`)
+	flag.Var(&channelLimit, "throttle-channel", `Command rate limit for each channel, in the same format as -throttle-nick.`)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type throttle struct {
+	limit   RateLimit
+	buckets map[string]*bucket
+}
+
+func newThrottle() *throttle {
+	return &throttle{buckets: make(map[string]*bucket)}
+}
+
+// Returns the bucket for key with the tokens regained since it was last used,
+// and how long it would take for it to have a token available.
+func (t *throttle) get(key string, now time.Time) (b *bucket, wait time.Duration) {
+	b, ok := t.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(t.limit.Burst), last: now}
+		t.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(t.limit.Burst), b.tokens+float64(now.Sub(b.last))/float64(t.limit.Every))
+	b.last = now
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) * float64(t.limit.Every))
+	}
+	return
+}
+
+// Forgets buckets that were refilled, to keep the maps from growing forever.
+func (t *throttle) sweep(now time.Time) {
+	for key, b := range t.buckets {
+		if now.Sub(b.last) >= time.Duration(t.limit.Burst)*t.limit.Every {
+			delete(t.buckets, key)
+		}
+	}
+}
+
+var (
+	nickThrottle    = newThrottle()
+	hostThrottle    = newThrottle()
+	channelThrottle = newThrottle()
+
+	// One throttle per command that has a Cooldown, keyed by command name.
+	cooldownThrottles = make(map[string]*throttle)
+
+	// Until when each nick has already been told to slow down.
+	throttleNotified = make(map[string]time.Time)
+	lastSweep        = time.Now()
+	throttleMutex    sync.Mutex
+)
+
+// Checks whether the request is within the configured rate limits, taking
+// a token from every bucket that applies to it if so. The first time a nick
+// gets throttled it is sent a NOTICE; requests are then silently dropped
+// until the nick would be allowed again.
+func (n *Network) checkThrottle(req *Request) bool {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+
+	// The limits may have been changed by a configuration reload
+	limits := getConfig().Throttle
+	nickThrottle.limit = limits.Nick
+	hostThrottle.limit = limits.Host
+	channelThrottle.limit = limits.Channel
+
+	now := time.Now()
+	if now.Sub(lastSweep) > 5*time.Minute {
+		for _, t := range append([]*throttle{nickThrottle, hostThrottle, channelThrottle}, throttlesOf(cooldownThrottles)...) {
+			t.sweep(now)
+		}
+		for nick, until := range throttleNotified {
+			if now.After(until) {
+				delete(throttleNotified, nick)
+			}
+		}
+		lastSweep = now
+	}
+
+	// Keys are prefixed with the network name, as nicks and channels
+	// on different networks are unrelated
+	prefix := n.Config().Name + " "
+	nick := prefix + strings.ToLower(req.Nick)
+	buckets := []*bucket{}
+	wait := time.Duration(0)
+	check := func(t *throttle, key string) {
+		if !t.limit.Enabled() {
+			return
+		}
+		b, w := t.get(key, now)
+		buckets = append(buckets, b)
+		if w > wait {
+			wait = w
+		}
+	}
+	check(nickThrottle, nick)
+	check(hostThrottle, prefix+strings.ToLower(req.Line.Ident+"@"+req.Line.Host))
+	if isChannel(req.Target) {
+		check(channelThrottle, prefix+strings.ToLower(req.Target))
+	}
+	if wait > 0 {
+		n.notifyThrottled(req.Nick, nick, wait, now)
+		return false
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	delete(throttleNotified, nick)
+	return true
+}
+
+// Checks whether the request's command is out of its cooldown in the channel,
+// taking the channel's token if so. Only called once the user passed
+// authentication, so that others can't keep the command locked.
+func (n *Network) checkCooldown(req *Request) bool {
+	if req.Command.Cooldown == nil {
+		return true
+	}
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+
+	name := req.Command.Name
+	if _, ok := cooldownThrottles[name]; !ok {
+		cooldownThrottles[name] = newThrottle()
+	}
+	t := cooldownThrottles[name]
+	t.limit = RateLimit{Burst: 1, Every: req.Command.Cooldown()}
+	if !t.limit.Enabled() {
+		return true
+	}
+
+	now := time.Now()
+	prefix := n.Config().Name + " "
+	b, wait := t.get(prefix+strings.ToLower(req.Target), now)
+	if wait > 0 {
+		n.notifyThrottled(req.Nick, prefix+strings.ToLower(req.Nick), wait, now)
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Sends a NOTICE telling nick to slow down, unless it was already told for
+// this wait. Must be called with throttleMutex held.
+func (n *Network) notifyThrottled(nick, key string, wait time.Duration, now time.Time) {
+	if now.After(throttleNotified[key]) {
+		secs := int(math.Ceil(wait.Seconds()))
+		n.Println("Throttling", nick, "for", secs, "seconds")
+		n.irc.Notice(nick, fmt.Sprintf("slow down, try again in %ds", secs))
+		throttleNotified[key] = now.Add(wait)
+	}
+}
+
+func throttlesOf(m map[string]*throttle) (l []*throttle) {
+	for _, t := range m {
+		l = append(l, t)
+	}
+	return
+}
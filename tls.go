@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+var (
+	sslCA           = flag.String("ssl-ca", "", `PEM file with the certificate authorities to trust, instead of the system's.`)
+	sslFingerprints = flag.String("ssl-fingerprints", "", `Comma-separated SHA-256 fingerprints of the server certificates to accept. When given, the certificate authorities are not checked.`)
+	sslCert         = flag.String("ssl-cert", "", `PEM file with a client certificate, for CertFP and SASL EXTERNAL.`)
+	sslKey          = flag.String("ssl-key", "", `PEM file with the client certificate's key. If blank, it's read from -ssl-cert.`)
+	sslInsecure     = flag.Bool("ssl-insecure", false, `Don't verify the server's certificate at all. Only for testing.`)
+)
+
+// Returns the certificate's SHA-256 fingerprint, in the same format as
+// "openssl x509 -fingerprint -sha256".
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// Puts a fingerprint in a canonical form, so that it can be given with or
+// without colons, and in any case.
+func normalizeFingerprint(fp string) (string, error) {
+	fp = strings.ToLower(strings.Replace(strings.TrimSpace(fp), ":", "", -1))
+	if b, err := hex.DecodeString(fp); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 fingerprint %q", fp)
+	}
+	return fp, nil
+}
+
+// Returned when the server's certificate doesn't match any pinned fingerprint.
+type pinError struct {
+	Fingerprint string
+}
+
+func (e *pinError) Error() string {
+	return fmt.Sprintf("certificate fingerprint %s is not pinned", e.Fingerprint)
+}
+
+// Builds the TLS settings used to connect to the network.
+func (cfg *NetworkConfig) tlsConfig() (*tls.Config, error) {
+	c := &tls.Config{}
+	if cfg.SSLInsecure {
+		c.InsecureSkipVerify = true
+	}
+	if cfg.SSLCA != "" {
+		pem, err := ioutil.ReadFile(cfg.SSLCA)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", cfg.SSLCA)
+		}
+	}
+	if cfg.SSLCert != "" {
+		key := cfg.SSLKey
+		if key == "" {
+			key = cfg.SSLCert
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.SSLCert, key)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if len(cfg.SSLFingerprints) > 0 && !cfg.SSLInsecure {
+		pins := make(map[string]bool)
+		for _, fp := range cfg.SSLFingerprints {
+			fp, err := normalizeFingerprint(fp)
+			if err != nil {
+				return nil, err
+			}
+			pins[fp] = true
+		}
+		// The pins replace the usual verification, so that self-signed
+		// certificates can be used
+		c.InsecureSkipVerify = true
+		c.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no certificate sent")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			fp := fingerprint(cert)
+			if n, _ := normalizeFingerprint(fp); !pins[n] {
+				return &pinError{fp}
+			}
+			return nil
+		}
+	}
+	return c, nil
+}
+
+// Explains why a connection failed, if it was because of the server's
+// certificate.
+func describeTLSError(err error) string {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+		pin              *pinError
+	)
+	switch {
+	case errors.As(err, &unknownAuthority):
+		msg := "the server's certificate is not signed by a trusted authority; trust its CA with ssl_ca, or pin it with ssl_fingerprints"
+		if unknownAuthority.Cert != nil {
+			msg += fmt.Sprintf(" (its fingerprint is %s)", fingerprint(unknownAuthority.Cert))
+		}
+		return msg
+	case errors.As(err, &hostname):
+		names := hostname.Certificate.DNSNames
+		if len(names) == 0 {
+			names = []string{hostname.Certificate.Subject.CommonName}
+		}
+		return fmt.Sprintf("the server's certificate is not valid for %s, only for %s",
+			hostname.Host, strings.Join(names, ", "))
+	case errors.As(err, &invalid):
+		if invalid.Reason == x509.Expired {
+			return fmt.Sprintf("the server's certificate is only valid from %v to %v",
+				invalid.Cert.NotBefore.Format(time.RFC3339), invalid.Cert.NotAfter.Format(time.RFC3339))
+		}
+		return "the server's certificate is invalid: " + invalid.Error()
+	case errors.As(err, &pin):
+		return fmt.Sprintf("the server's certificate fingerprint %s is not in ssl_fingerprints", pin.Fingerprint)
+	}
+	return ""
+}
+
+// Logs a connection error, explaining certificate verification failures.
+func (n *Network) logConnectError(what string, err error) {
+	n.Println(what+":", err)
+	if reason := describeTLSError(err); reason != "" {
+		n.Println("Certificate verification failed:", reason)
+	}
+}